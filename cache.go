@@ -0,0 +1,143 @@
+package mapreduce
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// side files are served as cache_<name> from the job's temp dir, and each
+// worker keeps its copies under a cache directory in its own
+const (
+	sideFilePrefix = "cache_"
+	sideFileDir    = "cache"
+)
+
+var sideFileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+func sideFile(name string) string {
+	return sideFilePrefix + name
+}
+
+// SideFile is a file the job ships to every worker before its tasks run,
+// such as a stop word list or model parameters
+type SideFile struct {
+	Name string // base name user code looks it up by
+	Sum  string // hex SHA-256 of the contents
+}
+
+// side files a worker has already checked against their digest, by local
+// path, so each task after the first skips both download and hashing
+var sideFileCache = struct {
+	sync.Mutex
+	sums map[string]string
+}{sums: make(map[string]string)}
+
+// fetchSideFiles makes sure dir holds a copy of every side file served by
+// host that matches its digest, downloading the ones it does not, and
+// returns a job context pointing at them
+func fetchSideFiles(logger *slog.Logger, trace *taskTrace, host, dir string, files []SideFile) (*JobContext, error) {
+	ctx := &JobContext{files: make(map[string]string)}
+	if len(files) == 0 {
+		return ctx, nil
+	}
+	cacheDir := filepath.Join(dir, sideFileDir)
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		logger.Error("making side file cache", "file", cacheDir, "err", err)
+		return nil, err
+	}
+	ctx.dir = cacheDir
+
+	sideFileCache.Lock()
+	defer sideFileCache.Unlock()
+	for _, file := range files {
+		path := filepath.Join(cacheDir, file.Name)
+		ctx.files[file.Name] = path
+		if _, err := os.Stat(path); err == nil {
+			if sideFileCache.sums[path] == file.Sum {
+				continue
+			}
+			if sum, _, err := fileChecksum(path); err == nil && sum == file.Sum {
+				sideFileCache.sums[path] = sum
+				continue
+			}
+		}
+
+		url := makeURL(host, sideFile(file.Name))
+		end := trace.begin("side file", "url", url)
+		err := download(logger, url, path)
+		end()
+		if err != nil {
+			logger.Error("downloading side file", "url", url, "err", err)
+			return nil, err
+		}
+		sum, _, err := fileChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+		if sum != file.Sum {
+			os.Remove(path)
+			err := fmt.Errorf("%w: side file %s has sha256 %s, job expects %s", errChecksumMismatch, file.Name, sum, file.Sum)
+			logger.Error("verifying side file", "file", path, "err", err)
+			return nil, err
+		}
+		logger.Info("cached side file", "file", path)
+		sideFileCache.sums[path] = sum
+	}
+	return ctx, nil
+}
+
+// prepareFiles copies the job's side files into its temp dir, where the
+// data server hands them to the tasks, and returns their names and digests
+func (job *Job) prepareFiles() ([]SideFile, error) {
+	var files []SideFile
+	seen := make(map[string]bool)
+	for _, source := range job.Files {
+		name := filepath.Base(source)
+		if !sideFileNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("side file %s: bad name %q", source, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("side file %s: another side file is already named %q", source, name)
+		}
+		seen[name] = true
+
+		dest := filepath.Join(job.TempDir, sideFile(name))
+		if err := copyFile(source, dest); err != nil {
+			return nil, err
+		}
+		if err := writeChecksum(dest); err != nil {
+			return nil, err
+		}
+		sum, _, err := readChecksum(dest)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, SideFile{Name: name, Sum: sum})
+	}
+	return files, nil
+}
+
+func copyFile(source, dest string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		slog.Error("opening file to copy", "file", source, "err", err)
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		slog.Error("creating copy", "file", dest, "err", err)
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		slog.Error("copying file", "file", dest, "err", err)
+		return err
+	}
+	return out.Close()
+}
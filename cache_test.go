@@ -0,0 +1,93 @@
+package mapreduce
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stopList counts values, leaving out the ones listed in its stop.txt
+// side file, which Map reads the first time it is called in a task
+type stopList struct {
+	ctx  *JobContext
+	stop map[string]bool
+}
+
+func (s *stopList) SetContext(ctx *JobContext) {
+	s.ctx, s.stop = ctx, nil
+}
+
+func (s *stopList) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	if s.stop == nil {
+		path, ok := s.ctx.File("stop.txt")
+		if !ok {
+			return fmt.Errorf("map task has no stop.txt")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		s.stop = make(map[string]bool)
+		for _, word := range strings.Fields(string(data)) {
+			s.stop[word] = true
+		}
+	}
+	if !s.stop[value] {
+		output <- Pair{Key: value, Value: "1"}
+	}
+	return nil
+}
+
+func (s *stopList) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	n := 0
+	for range values {
+		n++
+	}
+	output <- Pair{Key: key, Value: fmt.Sprint(n)}
+	return nil
+}
+
+func TestSideFiles(t *testing.T) {
+	var input []Pair
+	for i := 0; i < 30; i++ {
+		input = append(input, Pair{Key: fmt.Sprint(i), Value: fmt.Sprint(i % 5)})
+	}
+	side := filepath.Join(t.TempDir(), "stop.txt")
+	if err := os.WriteFile(side, []byte("1\n2\n3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fc := &FakeCluster{M: 3, R: 2, Workers: 2, TotalOrder: true, Files: []string{side}}
+	out, err := fc.Run(&stopList{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "0", Value: "6"}, {Key: "4", Value: "6"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+
+	if _, err := (&FakeCluster{M: 1, R: 1}).Run(&stopList{}, input); err == nil {
+		t.Error("job without the side file succeeded")
+	}
+}
+
+func TestSideFileNames(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", filepath.Join("sub", "a.txt")} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job := &Job{TempDir: t.TempDir(), Files: []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "a.txt")}}
+	if _, err := job.prepareFiles(); err == nil {
+		t.Error("two side files with the same name were accepted")
+	}
+}
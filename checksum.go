@@ -0,0 +1,97 @@
+package mapreduce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// every file a task hands to another task gets a sidecar file next to it
+// holding its SHA-256 digest and size; the data server reports these in
+// response headers so download can verify what it received
+const checksumSuffix = ".sum"
+
+const (
+	checksumHeader = "X-Checksum-Sha256"
+	sizeHeader     = "X-Checksum-Size"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+func checksumFile(path string) string {
+	return path + checksumSuffix
+}
+
+func fileChecksum(path string) (string, int64, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		slog.Error("opening file to compute checksum", "file", path, "err", err)
+		return "", 0, err
+	}
+	defer fp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, fp)
+	if err != nil {
+		slog.Error("reading file to compute checksum", "file", path, "err", err)
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func writeChecksum(path string) error {
+	sum, size, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(checksumFile(path), []byte(fmt.Sprintf("%s %d\n", sum, size)), 0600); err != nil {
+		slog.Error("writing checksum file", "file", path, "err", err)
+		return err
+	}
+	return nil
+}
+
+func readChecksum(path string) (string, int64, error) {
+	contents, err := os.ReadFile(checksumFile(path))
+	if err != nil {
+		return "", 0, err
+	}
+	var sum string
+	var size int64
+	if _, err := fmt.Sscanf(string(contents), "%s %d", &sum, &size); err != nil {
+		slog.Error("parsing checksum file", "file", path, "err", err)
+		return "", 0, err
+	}
+	return sum, size, nil
+}
+
+// setChecksumHeaders adds the recorded digest and size of the file at path
+// to a response serving it
+func setChecksumHeaders(w http.ResponseWriter, path string) {
+	if sum, size, err := readChecksum(path); err == nil {
+		w.Header().Set(checksumHeader, sum)
+		w.Header().Set(sizeHeader, strconv.FormatInt(size, 10))
+	}
+}
+
+// verifyChecksum compares what was downloaded against the headers the
+// data server sent with it
+func verifyChecksum(header http.Header, sum string, size int64) error {
+	want := header.Get(checksumHeader)
+	if want == "" {
+		return fmt.Errorf("%w: server sent no %s header", errChecksumMismatch, checksumHeader)
+	}
+	if wantSize := header.Get(sizeHeader); wantSize != "" && wantSize != strconv.FormatInt(size, 10) {
+		return fmt.Errorf("%w: expected %s bytes, got %d", errChecksumMismatch, wantSize, size)
+	}
+	if want != sum {
+		return fmt.Errorf("%w: expected sha256 %s, got %s", errChecksumMismatch, want, sum)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package mapreduce
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchVerifiesChecksum(t *testing.T) {
+	dir := t.TempDir()
+	contents := []byte(strings.Repeat("the cat and the dog\n", 100))
+	path := filepath.Join(dir, "map_0_output_0.db")
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeChecksum(path); err != nil {
+		t.Fatal(err)
+	}
+	h := newDataHandler(dir, 1, 1, "")
+
+	for name, tamper := range map[string]func(w http.ResponseWriter){
+		"intact":       nil,
+		"wrong digest": func(w http.ResponseWriter) { w.Header().Set(checksumHeader, strings.Repeat("0", 64)) },
+		"wrong size":   func(w http.ResponseWriter) { w.Header().Set(sizeHeader, "7") },
+		"no digest":    func(w http.ResponseWriter) { w.Header().Del(checksumHeader) },
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tamper != nil {
+				w = &tamperedHeaders{ResponseWriter: w, tamper: tamper}
+			}
+			h.ServeHTTP(w, r)
+		}))
+		target := filepath.Join(t.TempDir(), "fetched.db")
+		err := fetch(slog.Default(), server.URL+"/map_0_output_0.db", target)
+		server.Close()
+
+		if tamper == nil {
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got, _ := os.ReadFile(target); !bytes.Equal(got, contents) {
+				t.Errorf("%s: fetched file does not match", name)
+			}
+			continue
+		}
+		if !errors.Is(err, errChecksumMismatch) {
+			t.Errorf("%s: got %v, want a checksum mismatch", name, err)
+		}
+		if _, err := os.Stat(target); !os.IsNotExist(err) {
+			t.Errorf("%s: a file that failed its checksum was kept", name)
+		}
+	}
+}
+
+func TestMapTaskFailsWhenChecksumCannotBeWritten(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, mapSourceFile(0))
+	db, err := createDatabase(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertPair(0, 0, db, []Pair{{Key: "line 1", Value: "the cat"}}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := writeChecksum(source); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/data/", http.StripPrefix("/data", newDataHandler(dir, 1, 1, "")))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// a directory where the output's checksum goes makes writing it fail
+	work := t.TempDir()
+	if err := os.Mkdir(checksumFile(filepath.Join(work, mapOutputFile(0, 0))), 0700); err != nil {
+		t.Fatal(err)
+	}
+	task := &MapTask{M: 1, R: 1, N: 0, SourceHost: server.Listener.Addr().String()}
+	if err := task.Process(work, &Client{}); err == nil {
+		t.Error("map task succeeded without recording its output checksum")
+	}
+}
+
+// tamperedHeaders changes a response's headers just before they are sent
+type tamperedHeaders struct {
+	http.ResponseWriter
+	tamper  func(w http.ResponseWriter)
+	written bool
+}
+
+func (t *tamperedHeaders) WriteHeader(status int) {
+	if !t.written {
+		t.written = true
+		t.tamper(t.ResponseWriter)
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *tamperedHeaders) Write(p []byte) (int, error) {
+	if !t.written {
+		t.WriteHeader(http.StatusOK)
+	}
+	return t.ResponseWriter.Write(p)
+}
@@ -0,0 +1,9 @@
+// Command mapreduce counts the words in austen.db, or runs the job its
+// flags or spec file describe. See mapreduce.Main.
+package main
+
+import "mapreduce"
+
+func main() {
+	mapreduce.Main()
+}
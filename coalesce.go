@@ -0,0 +1,111 @@
+package mapreduce
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// partitionBytes adds up the size of every map output in dir by partition
+func partitionBytes(dir string, m, r int) ([]int64, error) {
+	sizes := make([]int64, r)
+	for i := 0; i < m; i++ {
+		for p := 0; p < r; p++ {
+			info, err := os.Stat(filepath.Join(dir, mapOutputFile(i, p)))
+			if err != nil {
+				slog.Error("measuring map output", "err", err)
+				return nil, err
+			}
+			sizes[p] += info.Size()
+		}
+	}
+	return sizes, nil
+}
+
+// coalescePartitions groups runs of neighbouring partitions into reduce
+// tasks of up to target bytes each. A partition bigger than target gets a
+// task of its own, as does every partition in alone. Keeping neighbours
+// together means a total-order job's outputs stay in order.
+func coalescePartitions(sizes []int64, target int64, alone map[int][]string) [][]int {
+	var groups [][]int
+	var current []int
+	var bytes int64
+	flush := func() {
+		if len(current) > 0 {
+			groups = append(groups, current)
+		}
+		current, bytes = nil, 0
+	}
+	for p, size := range sizes {
+		if _, ok := alone[p]; ok {
+			flush()
+			groups = append(groups, []int{p})
+			continue
+		}
+		if len(current) > 0 && bytes+size > target {
+			flush()
+		}
+		current = append(current, p)
+		bytes += size
+	}
+	flush()
+	return groups
+}
+
+// planReduces looks at the map outputs for hot keys and small partitions
+// and lays out the reduce tasks to match. A resumed job reuses the layout
+// in the journal, so its task numbers mean what they did before.
+func (job *Job) planReduces(mapHosts []string) ([]*ReduceTask, *skewReport, error) {
+	order := keyOrderOf(job.Client)
+	report, err := analyzeSkew(job.TempDir, job.M, job.R, order)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, combiner := findCombiner(job.Client)
+	salted := report.plan(job.M, combiner, order)
+	report.log()
+
+	var groups [][]int
+	if job.Journal != nil {
+		if groups, err = job.Journal.reduceLayout(job.ID, job.R); err != nil {
+			return nil, nil, err
+		}
+	}
+	if groups == nil {
+		for p := 0; p < job.R; p++ {
+			groups = append(groups, []int{p})
+		}
+		if job.CoalesceBytes > 0 {
+			sizes, err := partitionBytes(job.TempDir, job.M, job.R)
+			if err != nil {
+				return nil, nil, err
+			}
+			groups = coalescePartitions(sizes, job.CoalesceBytes, salted)
+			slog.Info("coalesced reduce partitions", "r", job.R, "tasks", len(groups), "target_bytes", job.CoalesceBytes)
+		}
+		if job.Journal != nil {
+			if err := job.Journal.recordReduceLayout(job.ID, groups); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var tasks []*ReduceTask
+	for i, group := range groups {
+		task := &ReduceTask{
+			M:           job.M,
+			R:           job.R,
+			N:           i,
+			Partitions:  group,
+			SourceHosts: mapHosts,
+			Worker:      job.Address,
+		}
+		if len(group) == 1 {
+			task.Hot = salted[group[0]]
+		}
+		tasks = append(tasks, task)
+	}
+	job.Status.setSkew(report)
+	job.Status.setReduces(groups)
+	return tasks, report, nil
+}
@@ -0,0 +1,41 @@
+package mapreduce
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestCoalescePartitions(t *testing.T) {
+	sizes := []int64{10, 20, 50, 200, 5, 5, 5, 90, 10}
+	got := coalescePartitions(sizes, 100, map[int][]string{6: {"hot"}})
+	want := [][]int{{0, 1, 2}, {3}, {4, 5}, {6}, {7, 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCoalescedJob(t *testing.T) {
+	var input []Pair
+	for i := 0; i < 3000; i++ {
+		input = append(input, Pair{Key: strconv.Itoa(i), Value: strconv.Itoa(i * 7919 % 2003)})
+	}
+	client := WithKeyOrder(countValues{}, NumericKeys)
+	job := &Job{Client: client, M: 3, R: 8, TotalOrder: true, CoalesceBytes: 1 << 20}
+	out := runJob(t, job, input)
+	if len(job.Status.Reduces) != 1 || len(job.Status.Reduces[0].Partitions) != 8 {
+		t.Errorf("small partitions were not coalesced: %d reduce tasks", len(job.Status.Reduces))
+	}
+	if len(out) != 2003 {
+		t.Fatalf("got %d keys, want 2003", len(out))
+	}
+	// coalescing neighbours keeps the total order
+	for i := 1; i < len(out); i++ {
+		if NumericKeys(out[i-1].Key, out[i].Key) >= 0 {
+			t.Fatalf("%s comes before %s", out[i-1].Key, out[i].Key)
+		}
+	}
+	if want := runJob(t, &Job{Client: client, M: 3, R: 8, TotalOrder: true}, input); !reflect.DeepEqual(out, want) {
+		t.Error("coalescing changed the output")
+	}
+}
@@ -0,0 +1,114 @@
+package mapreduce
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// set from the -compress flag; when on, workers ask for intermediate files
+// gzipped and the data server compresses them for clients that ask
+var compressTransfers bool
+
+// running totals of intermediate file bytes before and after compression
+type transferStats struct {
+	raw  atomic.Int64 // bytes of file contents
+	wire atomic.Int64 // bytes actually sent or received
+}
+
+var served, fetched transferStats
+
+func (s *transferStats) saved() int64 {
+	return s.raw.Load() - s.wire.Load()
+}
+
+func logTransferStats() {
+	slog.Info("served intermediate data", "bytes", served.raw.Load(), "wire_bytes", served.wire.Load(), "saved_bytes", served.saved())
+	slog.Info("fetched intermediate data", "bytes", fetched.raw.Load(), "wire_bytes", fetched.wire.Load(), "saved_bytes", fetched.saved())
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	g.wroteHeader = true
+	if status == http.StatusOK {
+		// the length of the file no longer matches what goes over the wire
+		g.Header().Del("Content-Length")
+		g.Header().Set("Content-Encoding", "gzip")
+		g.Header().Add("Vary", "Accept-Encoding")
+	} else {
+		g.gz = nil
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(p)
+	}
+	return g.gz.Write(p)
+}
+
+// compressHandler gzips successful responses from h for clients that accept it
+func compressHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !compressTransfers || !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gz := gzip.NewWriter(w)
+		gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		h.ServeHTTP(gw, r)
+		if gw.gz != nil {
+			if err := gz.Close(); err != nil {
+				slog.Error("finishing compressed response", "file", r.URL.Path, "err", err)
+			}
+		}
+	})
+}
+
+// decompressBody wraps a response body so the caller reads the original
+// file contents whether or not the server compressed them
+func decompressBody(res *http.Response) (io.Reader, func() error, error) {
+	wire := countingWriter{w: io.Discard, count: &fetched.wire}
+	body := io.TeeReader(res.Body, wire)
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		return body, func() error { return nil }, nil
+	}
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		slog.Error("reading compressed response", "url", res.Request.URL.String(), "err", err)
+		return nil, nil, err
+	}
+	return gz, gz.Close, nil
+}
@@ -0,0 +1,55 @@
+package mapreduce
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServedBytesCountedWithAndWithoutCompression(t *testing.T) {
+	dir := t.TempDir()
+	contents := []byte(strings.Repeat("the cat and the dog\n", 500))
+	if err := os.WriteFile(filepath.Join(dir, "map_0_source.db"), contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	h := newDataHandler(dir, 1, 1, "")
+
+	defer func(old bool) { compressTransfers = old }(compressTransfers)
+	for _, compress := range []bool{false, true} {
+		compressTransfers = compress
+		raw, wire := served.raw.Load(), served.wire.Load()
+
+		req := httptest.NewRequest("GET", "/map_0_source.db", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("compress %v: status %d", compress, rec.Code)
+		}
+
+		body := rec.Body.Bytes()
+		if compress {
+			gz, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = io.ReadAll(gz); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(body, contents) {
+			t.Errorf("compress %v: body does not match the file", compress)
+		}
+		if got := served.raw.Load() - raw; got != int64(len(contents)) {
+			t.Errorf("compress %v: counted %d raw bytes, want %d", compress, got, len(contents))
+		}
+		if got := served.wire.Load() - wire; got != int64(rec.Body.Len()) {
+			t.Errorf("compress %v: counted %d wire bytes, want %d", compress, got, rec.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package mapreduce
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobConf holds a job's parameters for user code, such as a pattern to
+// grep for or an n-gram length. Values are kept as strings and parsed by
+// the getters, which return fallback when the key is not set.
+type JobConf map[string]string
+
+func (c JobConf) String(key, fallback string) string {
+	if value, ok := c[key]; ok {
+		return value
+	}
+	return fallback
+}
+
+func (c JobConf) Int(key string, fallback int) (int, error) {
+	value, ok := c[key]
+	if !ok {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback, fmt.Errorf("job parameter %s: %w", key, err)
+	}
+	return i, nil
+}
+
+func (c JobConf) Float(key string, fallback float64) (float64, error) {
+	value, ok := c[key]
+	if !ok {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback, fmt.Errorf("job parameter %s: %w", key, err)
+	}
+	return f, nil
+}
+
+func (c JobConf) Bool(key string, fallback bool) (bool, error) {
+	value, ok := c[key]
+	if !ok {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback, fmt.Errorf("job parameter %s: %w", key, err)
+	}
+	return b, nil
+}
+
+func (c JobConf) Duration(key string, fallback time.Duration) (time.Duration, error) {
+	value, ok := c[key]
+	if !ok {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback, fmt.Errorf("job parameter %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// ConfigurableInterface is implemented by Interface implementations that
+// take job parameters. Process calls Configure before running every task,
+// on whichever worker runs it, and fails the task if it returns an error.
+// Configure has to store what it reads, so it is usually implemented on a
+// pointer.
+type ConfigurableInterface interface {
+	Interface
+	Configure(conf JobConf) error
+}
+
+// configure hands conf to client, or any client it wraps, if it takes
+// job parameters
+func configure(logger *slog.Logger, client Interface, conf JobConf) error {
+	ci, ok := findClient[ConfigurableInterface](client)
+	if !ok {
+		return nil
+	}
+	if err := ci.Configure(conf); err != nil {
+		logger.Error("Configure", "err", err)
+		return err
+	}
+	return nil
+}
+
+// confFlag collects repeated -conf key=value flags into a JobConf
+type confFlag JobConf
+
+func (f confFlag) String() string {
+	var params []string
+	for _, key := range JobConf(f).keys() {
+		params = append(params, key+"="+f[key])
+	}
+	return strings.Join(params, ",")
+}
+
+func (f confFlag) Set(param string) error {
+	key, value, ok := strings.Cut(param, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("job parameter %q is not key=value", param)
+	}
+	f[key] = value
+	return nil
+}
+
+func (c JobConf) keys() []string {
+	var keys []string
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
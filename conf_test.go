@@ -0,0 +1,115 @@
+package mapreduce
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// grep counts the values matching the "pattern" job parameter, keeping
+// those seen at least "min" times
+type grep struct {
+	pattern *regexp.Regexp
+	min     int
+}
+
+func (g *grep) Configure(conf JobConf) error {
+	pattern, err := regexp.Compile(conf.String("pattern", "."))
+	if err != nil {
+		return err
+	}
+	g.pattern = pattern
+	g.min, err = conf.Int("min", 1)
+	return err
+}
+
+func (g *grep) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	if g.pattern.MatchString(value) {
+		output <- Pair{Key: value, Value: "1"}
+	}
+	return nil
+}
+
+func (g *grep) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	n := 0
+	for range values {
+		n++
+	}
+	if n >= g.min {
+		output <- Pair{Key: key, Value: strconv.Itoa(n)}
+	}
+	return nil
+}
+
+func TestConfigureGetsJobParameters(t *testing.T) {
+	var input []Pair
+	for i := 0; i < 300; i++ {
+		input = append(input, Pair{Key: strconv.Itoa(i), Value: "w" + strconv.Itoa(i%40)})
+	}
+	// wrapped, and with a sample run before the map tasks
+	fc := &FakeCluster{M: 3, R: 2, TotalOrder: true, Conf: JobConf{"pattern": "^w1", "min": "8"}}
+	out, err := fc.Run(WithKeyOrder(&grep{}, nil), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// w1 and w10..w19 come up 8 times each in 300 records
+	if len(out) != 11 {
+		t.Errorf("got %v, want the 11 values starting w1", out)
+	}
+	for _, pair := range out {
+		if !strings.HasPrefix(pair.Key, "w1") {
+			t.Errorf("%s does not match the pattern", pair.Key)
+		}
+	}
+
+	fc.Conf["min"] = "lots"
+	if _, err := fc.Run(&grep{}, input); err == nil || !strings.Contains(err.Error(), "job parameter min") {
+		t.Errorf("bad parameter gave %v, want the task to fail", err)
+	}
+}
+
+func TestJobConfGetters(t *testing.T) {
+	conf := JobConf{"n": "3", "f": "2.5", "b": "true", "d": "1m", "bad": "x"}
+	if got := conf.String("missing", "def"); got != "def" {
+		t.Errorf("String fallback %q", got)
+	}
+	if got, err := conf.Int("n", 0); got != 3 || err != nil {
+		t.Errorf("Int gave %d, %v", got, err)
+	}
+	if got, err := conf.Float("f", 0); got != 2.5 || err != nil {
+		t.Errorf("Float gave %g, %v", got, err)
+	}
+	if got, err := conf.Bool("b", false); !got || err != nil {
+		t.Errorf("Bool gave %v, %v", got, err)
+	}
+	if got, err := conf.Duration("d", 0); got != time.Minute || err != nil {
+		t.Errorf("Duration gave %v, %v", got, err)
+	}
+	if got, err := conf.Int("missing", 7); got != 7 || err != nil {
+		t.Errorf("Int fallback gave %d, %v", got, err)
+	}
+	if got, err := conf.Int("bad", 7); got != 7 || err == nil {
+		t.Errorf("bad Int gave %d, %v", got, err)
+	}
+}
+
+func TestConfFlag(t *testing.T) {
+	conf := make(JobConf)
+	for _, param := range []string{"pattern=a=b", "n=3", "empty="} {
+		if err := confFlag(conf).Set(param); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := confFlag(conf).String(), "empty=,n=3,pattern=a=b"; got != want {
+		t.Errorf("flag is %q, want %q", got, want)
+	}
+	for _, param := range []string{"novalue", "=x"} {
+		if err := confFlag(conf).Set(param); err == nil {
+			t.Errorf("%q accepted", param)
+		}
+	}
+}
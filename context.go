@@ -0,0 +1,109 @@
+package mapreduce
+
+import "log/slog"
+
+// JobContext tells user code about the task it is running in. Process
+// hands it to SetContext before the task's first record.
+type JobContext struct {
+	phase    string // "map", "reduce", "combine" or "sample"
+	counters *Counters
+	lookup   *Lookup           // map tasks only
+	files    map[string]string // side file name to local path
+	dir      string            // directory holding the side files, if any
+	conf     JobConf
+	logger   *slog.Logger
+}
+
+// ContextInterface is implemented by Interface implementations that want
+// the running task's context, to bump its counters and the like. Process
+// calls SetContext before the task's first record, and again for every
+// task after it, so SetContext has to store ctx somewhere Map and Reduce
+// can see it and is usually implemented on a pointer.
+type ContextInterface interface {
+	Interface
+	SetContext(ctx *JobContext)
+}
+
+// setContext hands ctx to client, or any client it wraps, if it wants it
+func setContext(client Interface, ctx *JobContext) {
+	if ci, ok := findClient[ContextInterface](client); ok {
+		ci.SetContext(ctx)
+	}
+}
+
+// findClient looks for an implementation of T on client or any client it
+// wraps, following Unwrap methods
+func findClient[T any](client Interface) (T, bool) {
+	for {
+		if t, ok := client.(T); ok {
+			return t, true
+		}
+		wrapper, ok := client.(interface{ Unwrap() Interface })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		client = wrapper.Unwrap()
+	}
+}
+
+// Phase returns which kind of task is running. It is "sample" when the
+// driver runs Map on sampled records to pick a total order's bounds.
+func (c *JobContext) Phase() string {
+	if c == nil {
+		return ""
+	}
+	return c.phase
+}
+
+// Counters returns the task's counters, which the driver sums into job
+// totals. Adding to them is safe even outside a task.
+func (c *JobContext) Counters() *Counters {
+	if c == nil {
+		return nil
+	}
+	return c.counters
+}
+
+// Lookup returns the job's lookup table in a map task, or nil. Reading a
+// nil table finds nothing.
+func (c *JobContext) Lookup() *Lookup {
+	if c == nil {
+		return nil
+	}
+	return c.lookup
+}
+
+// File returns the local path of the side file with the given base name
+func (c *JobContext) File(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	path, ok := c.files[name]
+	return path, ok
+}
+
+// Dir returns the directory holding the side files, or "" for a job
+// without any
+func (c *JobContext) Dir() string {
+	if c == nil {
+		return ""
+	}
+	return c.dir
+}
+
+// Conf returns the job's parameters
+func (c *JobContext) Conf() JobConf {
+	if c == nil {
+		return nil
+	}
+	return c.conf
+}
+
+// Logger returns the task's logger, so user code can write to the task log
+func (c *JobContext) Logger() *slog.Logger {
+	if c == nil || c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
@@ -0,0 +1,86 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Counters are named totals that Map and Reduce can bump while a task
+// runs, like malformed records skipped or distinct keys seen. Each task
+// gets its own set, from JobContext.Counters; the driver adds them up into
+// job totals.
+type Counters struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func NewCounters() *Counters {
+	return &Counters{values: make(map[string]int64)}
+}
+
+// Add increments a counter. It is safe to call on a nil *Counters, so user
+// code does not need to check whether counters were handed to it.
+func (c *Counters) Add(name string, delta int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.values[name] += delta
+	c.mu.Unlock()
+}
+
+func (c *Counters) Get(name string) int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[name]
+}
+
+// Snapshot returns a copy of the current values
+func (c *Counters) Snapshot() map[string]int64 {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.values) == 0 {
+		return nil
+	}
+	out := make(map[string]int64, len(c.values))
+	for name, value := range c.values {
+		out[name] = value
+	}
+	return out
+}
+
+func (c *Counters) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Snapshot())
+}
+
+// Merge adds a snapshot from another set of counters into c
+func (c *Counters) Merge(values map[string]int64) {
+	for name, value := range values {
+		c.Add(name, value)
+	}
+}
+
+func logCounters(counters *Counters) {
+	values := counters.Snapshot()
+	if len(values) == 0 {
+		return
+	}
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var attrs []any
+	for _, name := range names {
+		attrs = append(attrs, slog.Int64(name, values[name]))
+	}
+	slog.Info("job counters", slog.Group("counters", attrs...))
+}
@@ -0,0 +1,228 @@
+package mapreduce
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Stage is one map/reduce step of a DAG. Its map inputs are split from
+// its own Sources plus the reduce outputs of every parent stage. A join
+// stage joins each source and each parent, in that order, so its Join
+// needs that many Sides.
+type Stage struct {
+	Name    string
+	Client  Interface
+	M, R    int
+	Sources []string // pairs databases read directly
+	Parents []string // names of stages whose reduce outputs feed this one
+
+	TotalOrder    bool     // see Job.TotalOrder
+	CoalesceBytes int64    // see Job.CoalesceBytes
+	Lookup        string   // see Job.Lookup
+	Files         []string // see Job.Files
+	Conf          JobConf  // see Job.Conf
+	Spec          *JobSpec // see Job.Spec
+}
+
+// DAG runs a set of stages, each after all of its parents, on this node.
+// The reduce_N_output.db files of a stage are split straight into the map
+// inputs of the stages that depend on it.
+type DAG struct {
+	ID      string
+	Dir     string // each stage runs in a subdirectory named after it
+	Address string // host:port where workers reach this node's /data/ handler
+	Stages  []*Stage
+
+	Data    *handlerSwitch // pointed at the running stage's data handler
+	Status  *handlerSwitch // pointed at the running stage's status; may be nil
+	Journal *journal       // lets a rerun skip finished stages; may be nil
+}
+
+// order returns the stages so that every stage comes after its parents
+func (d *DAG) order() ([]*Stage, error) {
+	byName := make(map[string]*Stage)
+	for _, s := range d.Stages {
+		if _, dup := byName[s.Name]; dup {
+			return nil, fmt.Errorf("stage %q is defined twice", s.Name)
+		}
+		if s.M < 1 || s.R < 0 {
+			return nil, fmt.Errorf("stage %q needs M of at least 1 and R of at least 0", s.Name)
+		}
+		byName[s.Name] = s
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var sorted []*Stage
+	var visit func(s *Stage) error
+	visit = func(s *Stage) error {
+		switch state[s.Name] {
+		case visiting:
+			return fmt.Errorf("stage %q depends on itself", s.Name)
+		case visited:
+			return nil
+		}
+		state[s.Name] = visiting
+		for _, name := range s.Parents {
+			parent, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("stage %q has unknown parent %q", s.Name, name)
+			}
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		state[s.Name] = visited
+		sorted = append(sorted, s)
+		return nil
+	}
+	for _, s := range d.Stages {
+		if err := visit(s); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// sinks returns the stages no other stage reads from, whose outputs are
+// the DAG's result
+func (d *DAG) sinks() []*Stage {
+	parents := make(map[string]bool)
+	for _, s := range d.Stages {
+		for _, name := range s.Parents {
+			parents[name] = true
+		}
+	}
+	var sinks []*Stage
+	for _, s := range d.Stages {
+		if !parents[s.Name] {
+			sinks = append(sinks, s)
+		}
+	}
+	return sinks
+}
+
+// Run runs every stage and returns the reduce output files of each one
+func (d *DAG) Run() (map[string][]string, error) {
+	stages, err := d.order()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make(map[string][]string)
+	for _, stage := range stages {
+		dir := filepath.Join(d.Dir, stage.Name)
+		sources := append([]string(nil), stage.Sources...)
+		var sides []int
+		for i := range stage.Sources {
+			sides = append(sides, i)
+		}
+		for i, parent := range stage.Parents {
+			sources = append(sources, outputs[parent]...)
+			for range outputs[parent] {
+				sides = append(sides, len(stage.Sources)+i)
+			}
+		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("stage %q has no sources and no parents", stage.Name)
+		}
+
+		job := &Job{
+			ID:      d.ID + "." + stage.Name,
+			Sources: sources,
+			M:       stage.M,
+			R:       stage.R,
+			TempDir: dir,
+			Address: d.Address,
+			Client:  stage.Client,
+			Status:  newJobStatus(fmt.Sprintf("stage %s", stage.Name), stage.M, stage.R),
+			Journal: d.Journal,
+
+			SourceSides:   sides,
+			TotalOrder:    stage.TotalOrder,
+			CoalesceBytes: stage.CoalesceBytes,
+			Lookup:        stage.Lookup,
+			Files:         stage.Files,
+			Conf:          stage.Conf,
+			Spec:          stage.Spec,
+		}
+		if err := runStage(job, d.Data, d.Status); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		outputs[stage.Name] = job.Outputs()
+	}
+	return outputs, nil
+}
+
+// runStage runs a job in its own directory on a server that may already
+// have served other jobs. With a journal, a job that already finished is
+// skipped and one that stopped part way is resumed.
+func runStage(job *Job, data, status *handlerSwitch) error {
+	logger := slog.Default().With("stage", job.ID)
+
+	fresh := true
+	if job.Journal != nil {
+		state, err := job.Journal.jobState(job.ID)
+		if err != nil {
+			return err
+		}
+		if state != "" && job.Spec != nil {
+			journaled, err := job.Journal.spec(job.ID)
+			if err != nil {
+				return err
+			}
+			if journaled != nil && !journaled.same(job.Spec) {
+				return fmt.Errorf("journal has %s with different settings; give the job a new ID to run it with these", job.ID)
+			}
+		}
+		switch state {
+		case "done":
+			outputs, err := job.Journal.outputs(job.ID, job.outputPhase())
+			if err != nil {
+				return err
+			}
+			if len(outputs) > 0 && outputsIntact(outputs) {
+				logger.Info("stage already finished")
+				job.addOutputs(outputs)
+				counters, err := job.Journal.counters(job.ID)
+				if err != nil {
+					return err
+				}
+				job.Status.Counters.Merge(counters)
+				return nil
+			}
+			return fmt.Errorf("journal says %s finished but its outputs are missing or damaged", job.ID)
+		case "running":
+			logger.Info("resuming stage")
+			fresh = false
+		}
+	}
+
+	if fresh {
+		if err := os.RemoveAll(job.TempDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(job.TempDir, 0700); err != nil {
+			return err
+		}
+		if job.Journal != nil {
+			if err := job.Journal.createJob(job); err != nil {
+				return err
+			}
+		}
+	}
+
+	data.set(http.StripPrefix("/data", newDataHandler(job.TempDir, job.M, job.R, dataToken)))
+	if status != nil {
+		status.set(job.Status)
+	}
+	logger.Info("running stage", "file", job.source(), "m", job.M, "r", job.R)
+	return job.Run()
+}
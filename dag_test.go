@@ -0,0 +1,100 @@
+package mapreduce
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// invert turns word counts into the words seen each number of times
+type invert struct{}
+
+func (invert) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	output <- Pair{Key: value, Value: key}
+	return nil
+}
+
+func (invert) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	var words []string
+	for value := range values {
+		words = append(words, value)
+	}
+	sort.Strings(words)
+	output <- Pair{Key: key, Value: strings.Join(words, " ")}
+	return nil
+}
+
+func countAndInvert(dir string, source string, count Interface) *DAG {
+	return &DAG{ID: "dag", Dir: filepath.Join(dir, "dag"), Stages: []*Stage{
+		{Name: "invert", Client: invert{}, M: 2, R: 1, Parents: []string{"count"}},
+		{Name: "count", Client: count, M: 3, R: 2, Sources: []string{source}},
+	}}
+}
+
+func TestDAGRunsStagesAfterParents(t *testing.T) {
+	dir := t.TempDir()
+	source := writeSource(t, dir, lines(30, "the cat and the dog"))
+	out, err := (&FakeCluster{}).RunDAG(countAndInvert(dir, source, &Client{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "30", Value: "and cat dog"}, {Key: "60", Value: "the"}}
+	if !reflect.DeepEqual(out["invert"], want) {
+		t.Errorf("invert stage gave %v, want %v", out["invert"], want)
+	}
+	if len(out["count"]) != 4 {
+		t.Errorf("count stage gave %v, want four words", out["count"])
+	}
+}
+
+func TestDAGRerunSkipsFinishedStages(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := openJournal(filepath.Join(dir, "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	source := writeSource(t, dir, lines(30, "the cat and the dog"))
+
+	d := countAndInvert(dir, source, &Client{})
+	d.Journal = journal
+	want, err := (&FakeCluster{}).RunDAG(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a count stage that ran again would fail every map task
+	d = countAndInvert(dir, source, failingClient{&Client{}, true})
+	d.Journal = journal
+	got, err := (&FakeCluster{}).RunDAG(d)
+	if err != nil {
+		t.Fatalf("rerun ran the finished stage again: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rerun gave %v, want %v", got, want)
+	}
+}
+
+func TestDAGRejectsBadStages(t *testing.T) {
+	for name, stages := range map[string][]*Stage{
+		"cycle": {
+			{Name: "a", Client: &Client{}, M: 1, R: 1, Parents: []string{"b"}},
+			{Name: "b", Client: &Client{}, M: 1, R: 1, Parents: []string{"a"}},
+		},
+		"unknown parent": {{Name: "a", Client: &Client{}, M: 1, R: 1, Parents: []string{"b"}}},
+		"duplicate": {
+			{Name: "a", Client: &Client{}, M: 1, R: 1, Sources: []string{"x.db"}},
+			{Name: "a", Client: &Client{}, M: 1, R: 1, Sources: []string{"x.db"}},
+		},
+		"no sources": {{Name: "a", Client: &Client{}, M: 1, R: 1}},
+	} {
+		d := &DAG{ID: "bad", Dir: t.TempDir(), Stages: stages}
+		if _, err := (&FakeCluster{}).RunDAG(d); err == nil {
+			t.Errorf("%s: DAG ran", name)
+		}
+	}
+}
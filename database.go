@@ -1,13 +1,16 @@
-package main
+package mapreduce
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -15,10 +18,10 @@ import (
 func openDatabase(path string) (*sql.DB, error) {
 	if _, err := os.Stat(path); err != nil {
 		if os.IsNotExist(err) {
-			log.Printf("openDatabase: file [%s] does not exist", path)
+			slog.Error("database file does not exist", "file", path)
 			return nil, err
 		} else {
-			log.Printf("openDatabase: error trying to access source file [%s]: %v", path, err)
+			slog.Error("accessing database file", "file", path, "err", err)
 			return nil, err
 		}
 	}
@@ -33,7 +36,7 @@ func openDatabase(path string) (*sql.DB, error) {
 			"&" + "_synchronous=OFF"
 	db, err := sql.Open("sqlite3", path+options)
 	if err != nil {
-		log.Printf("error opening database [%s]: %v", path, err)
+		slog.Error("opening database", "file", path, "err", err)
 		return nil, err
 	}
 
@@ -54,11 +57,11 @@ func createDatabase(path string) (*sql.DB, error) {
 			"&" + "_synchronous=OFF"
 	db, err := sql.Open("sqlite3", path+options)
 	if err != nil {
-		log.Printf("error creating database [%s]: %v", path, err)
+		slog.Error("creating database", "file", path, "err", err)
 		return nil, err
 	}
 	if _, err = db.Exec("create table pairs (key text, value text)"); err != nil {
-		log.Printf("error creating table for database [%s]: %v", path, err)
+		slog.Error("creating table for database", "file", path, "err", err)
 		db.Close()
 		return nil, err
 	}
@@ -67,12 +70,14 @@ func createDatabase(path string) (*sql.DB, error) {
 }
 
 func splitDatabase(source string, paths []string) error {
-	db, err := openDatabase(source)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
+	return splitDatabases([]string{source}, paths, nil)
+}
 
+// splitDatabases deals the pairs of every source round-robin into the
+// output databases, so several inputs can feed one job without first being
+// merged into a single file. With sides set each value is prefixed with
+// the join side its source belongs to, sides[i] for sources[i].
+func splitDatabases(sources []string, paths []string, sides []int) error {
 	// create output databases
 	var outs []*sql.DB
 	var inserts []*sql.Stmt
@@ -98,7 +103,7 @@ func splitDatabase(source string, paths []string) error {
 		outs = append(outs, out)
 		insert, err := out.Prepare("insert into pairs (key, value) values (?, ?)")
 		if err != nil {
-			log.Printf("error preparing statement for output database: %v", err)
+			slog.Error("preparing statement for output database", "file", path, "err", err)
 			return err
 		}
 		inserts = append(inserts, insert)
@@ -106,113 +111,217 @@ func splitDatabase(source string, paths []string) error {
 
 	// process input pairs
 	dbi := 0
+	for i, source := range sources {
+		prefix := ""
+		if sides != nil {
+			prefix = tagPrefix(sides[i])
+		}
+		if err := splitInto(source, prefix, inserts, paths, &dbi); err != nil {
+			return err
+		}
+	}
+
+	// close the outputs so their checksums cover everything written to them
+	for i := range outs {
+		inserts[i].Close()
+		inserts[i] = nil
+		if err := outs[i].Close(); err != nil {
+			slog.Error("closing output database", "file", paths[i], "err", err)
+			return err
+		}
+		outs[i] = nil
+		if err := writeChecksum(paths[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitInto continues the round-robin from one source into the outputs,
+// putting prefix in front of every value
+func splitInto(source, prefix string, inserts []*sql.Stmt, paths []string, dbi *int) error {
+	db, err := openDatabase(source)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
 	rows, err := db.Query("select key, value from pairs")
 	if err != nil {
-		log.Printf("error in select query from database to split: %v", err)
+		slog.Error("select query from database to split", "file", source, "err", err)
 		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var key, value string
 		if err := rows.Scan(&key, &value); err != nil {
-			log.Printf("error scanning row value: %v", err)
+			slog.Error("scanning row value", "file", source, "err", err)
 			return err
 		}
 
 		// round-robin through the output databases
-		insert := inserts[dbi]
-		if _, err := insert.Exec(key, value); err != nil {
-			log.Printf("db error inserting row to output database: %v", err)
+		insert := inserts[*dbi]
+		if _, err := insert.Exec(key, prefix+value); err != nil {
+			slog.Error("inserting row to output database", "file", paths[*dbi], "err", err)
 			return err
 		}
-		dbi = (dbi + 1) % len(inserts)
+		*dbi = (*dbi + 1) % len(inserts)
 	}
 	if err := rows.Err(); err != nil {
-		log.Printf("db error iterating over inputs: %v", err)
+		slog.Error("iterating over inputs", "file", source, "err", err)
 		return err
 	}
 	return nil
 }
 
-func mergeDatabases(urls []string, path string, temp string) (*sql.DB, error) {
+func mergeDatabases(logger *slog.Logger, trace *taskTrace, urls []string, path string, temp string) (*sql.DB, int64, error) {
 	// create the output file
 	db, err := createDatabase(path)
 	//fmt.Println("This is the err ", err)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// gather them one at a time
+	var bytes int64
 	for _, u := range urls {
-		if err := download(u, temp); err != nil {
+		end := trace.begin("download", "url", u)
+		err := download(logger, u, temp)
+		end()
+		if err != nil {
 			//fmt.Println("Download err ", err)
 			db.Close()
-			return nil, err
+			return nil, 0, err
+		}
+		if info, err := os.Stat(temp); err == nil {
+			bytes += info.Size()
 		}
-		if err := gatherInto(db, temp); err != nil {
+		end = trace.begin("gather", "url", u)
+		err = gatherInto(logger, db, temp)
+		end()
+		if err != nil {
 			//fmt.Println("Gather err ", err)
 			db.Close()
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
-	return db, nil
+	return db, bytes, nil
+}
+
+// number of times download will try to fetch a file before giving up
+const downloadAttempts = 3
+
+func download(logger *slog.Logger, url, path string) error {
+	start := time.Now()
+	defer func() {
+		downloadMetric.observe(time.Since(start).Seconds())
+	}()
+
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if err = fetch(logger, url, path); err == nil {
+			return nil
+		}
+		logger.Warn("download attempt failed", "url", url, "file", path, "try", attempt, "of", downloadAttempts, "err", err)
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	return err
 }
 
-func download(url, path string) error {
+func fetch(logger *slog.Logger, url, path string) error {
 	// issue a GET request to retrieve a file
-	res, err := http.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Printf("error in GET request for %s: %v", url, err)
+		logger.Error("building GET request", "url", url, "err", err)
+		return err
+	}
+	if compressTransfers {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if dataToken != "" {
+		req.Header.Set("Authorization", "Bearer "+dataToken)
+	}
+	res, err := dataClient.Do(req)
+	if err != nil {
+		logger.Error("GET request", "url", url, "err", err)
 		return err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
 		err := fmt.Errorf("GET request returned %s for %s", res.Status, url)
-		log.Printf("%v", err)
+		logger.Error("GET request", "url", url, "status", res.StatusCode, "err", err)
 		return err
 	}
 
 	//fmt.Println(path)
 	fp, err := os.Create(path)
 	if err != nil {
-		log.Printf("error creating intermediate file %s for download: %v", path, err)
+		logger.Error("creating intermediate file for download", "file", path, "err", err)
+		return err
+	}
+
+	body, closeBody, err := decompressBody(res)
+	if err != nil {
+		fp.Close()
+		os.Remove(path)
 		return err
 	}
-	_, err = io.Copy(fp, res.Body)
+
+	// hash the file as it comes in so it can be checked against the server's digest
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fp, hash), body)
+	if err == nil {
+		err = closeBody()
+	}
 	fp.Close()
+	fetched.raw.Add(size)
 	if err != nil {
-		log.Printf("error downloading file %s from %s: %v", path, url, err)
+		logger.Error("downloading file", "file", path, "url", url, "err", err)
+		os.Remove(path)
+		return err
+	}
+	if err := verifyChecksum(res.Header, hex.EncodeToString(hash.Sum(nil)), size); err != nil {
+		logger.Error("verifying downloaded file", "file", path, "url", url, "err", err)
+		os.Remove(path)
 		return err
 	}
 	return nil
 }
 
-func gatherInto(db *sql.DB, path string) error {
+func gatherInto(logger *slog.Logger, db *sql.DB, path string) error {
+	if err := mergeFrom(logger, db, path); err != nil {
+		return err
+	}
+
+	// might as well delete it now; might even save some disk writes
+	return os.Remove(path)
+}
+
+// mergeFrom copies every pair in the database at path into db
+func mergeFrom(logger *slog.Logger, db *sql.DB, path string) error {
 	// attach the new file to the open database and merge it in
 	if _, err := db.Exec("attach ? as merge", path); err != nil {
-		log.Printf("error in attach command: %v", err)
+		logger.Error("attach command", "file", path, "err", err)
 		return err
 	}
 	if _, err := db.Exec("pragma merge.synchronous = off"); err != nil {
-		log.Printf("error disabling synchronous writes for merge database: %v", err)
+		logger.Error("disabling synchronous writes for merge database", "file", path, "err", err)
 		return err
 	}
 	if _, err := db.Exec("pragma merge.journal_mode = off"); err != nil {
-		log.Printf("error disabling journaling for merge database: %v", err)
+		logger.Error("disabling journaling for merge database", "file", path, "err", err)
 		return err
 	}
 	if _, err := db.Exec("insert into pairs select key, value from merge.pairs"); err != nil {
-		log.Printf("error in merge insert: %v", err)
+		logger.Error("merge insert", "file", path, "err", err)
 		return err
 	}
 	if _, err := db.Exec("detach merge"); err != nil {
-		log.Printf("error in detach command: %v", err)
+		logger.Error("detach command", "file", path, "err", err)
 		return err
 	}
-
-	// might as well delete it now; might even save some disk writes
-	return os.Remove(path)
+	return nil
 }
 
 // Part 2
@@ -221,19 +330,19 @@ func getNumberOfRows(path string) (int, error) {
 	var number_of_rows string
 	db, err := openDatabase(path)
 	if err != nil {
-		log.Printf("error in op")
+		slog.Error("opening database", "file", path, "err", err)
 		return 0, err
 
 	}
 	defer db.Close()
 
 	rows, err := db.Query("select count(1) from pairs")
-	defer rows.Close()
 	if err != nil {
 
-		log.Printf("error in select query from database to count: %v", err)
+		slog.Error("select query from database to count", "file", path, "err", err)
 		return 0, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		if err = rows.Scan(&number_of_rows); err != nil {
@@ -254,7 +363,7 @@ func getDatabaseSize(path string) (int, int, error) {
 	var page_size string
 	db, err := openDatabase(path)
 	if err != nil {
-		log.Printf("error in op")
+		slog.Error("opening database", "file", path, "err", err)
 		return 0, 0, err
 
 	}
@@ -262,7 +371,7 @@ func getDatabaseSize(path string) (int, int, error) {
 
 	rows, err := db.Query("PRAGMA page_count")
 	if err != nil {
-		log.Printf("error in pragma query from database to page_count: %v", err)
+		slog.Error("pragma query from database to page_count", "file", path, "err", err)
 		return 0, 0, err
 	}
 
@@ -275,7 +384,7 @@ func getDatabaseSize(path string) (int, int, error) {
 
 	rows, err = db.Query("PRAGMA page_size")
 	if err != nil {
-		log.Printf("error in pragma query from database to page_size: %v", err)
+		slog.Error("pragma query from database to page_size", "file", path, "err", err)
 		return 0, 0, err
 	}
 
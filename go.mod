@@ -1,5 +1,5 @@
-module main
+module mapreduce
 
 go 1.22.2
 
-require github.com/mattn/go-sqlite3 v1.14.22 // indirect
+require github.com/mattn/go-sqlite3 v1.14.22
@@ -0,0 +1,170 @@
+package mapreduce
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// set from the -token flag; when not empty the data server only answers
+// requests carrying it as a bearer token, and download sends it
+var dataToken string
+
+var (
+	mapSourcePattern     = regexp.MustCompile(`^map_(\d+)_source\.db$`)
+	mapOutputPattern     = regexp.MustCompile(`^map_(\d+)_output_(\d+)\.db$`)
+	combineOutputPattern = regexp.MustCompile(`^combine_(\d+)_output_(\d+)\.db$`)
+)
+
+// dataHandler serves the files other workers need from a job's temp dir:
+// map input splits, map outputs, combine outputs, the lookup table and side
+// files, and nothing else
+type dataHandler struct {
+	dir   string
+	M, R  int
+	token string
+}
+
+func newDataHandler(dir string, m, r int, token string) http.Handler {
+	return &dataHandler{dir: dir, M: m, R: r, token: token}
+}
+
+// allowed reports whether name is a file this job's workers may fetch
+func (h *dataHandler) allowed(name string) bool {
+	if name == lookupFile {
+		return true
+	}
+	if side, ok := strings.CutPrefix(name, sideFilePrefix); ok && sideFileNamePattern.MatchString(side) {
+		return true
+	}
+	if match := mapSourcePattern.FindStringSubmatch(name); match != nil {
+		m, err := strconv.Atoi(match[1])
+		return err == nil && m < h.M
+	}
+	if match := mapOutputPattern.FindStringSubmatch(name); match != nil {
+		m, err := strconv.Atoi(match[1])
+		if err != nil || m >= h.M {
+			return false
+		}
+		r, err := strconv.Atoi(match[2])
+		return err == nil && r < h.R
+	}
+	if match := combineOutputPattern.FindStringSubmatch(name); match != nil {
+		r, err := strconv.Atoi(match[1])
+		if err != nil || r >= h.R {
+			return false
+		}
+		salt, err := strconv.Atoi(match[2])
+		return err == nil && salt < h.M
+	}
+	return false
+}
+
+func (h *dataHandler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (h *dataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	defer func() {
+		slog.Info("data request", "remote", r.RemoteAddr, "method", r.Method, "file", r.URL.Path, "status", rec.status, "bytes", rec.bytes, "duration", time.Since(start))
+		if rec.status == http.StatusOK || rec.status == http.StatusPartialContent {
+			servedBytesMetric.add(float64(rec.bytes))
+			served.wire.Add(rec.bytes)
+		}
+	}()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(rec, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		http.Error(rec, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !h.allowed(name) {
+		http.NotFound(rec, r)
+		return
+	}
+	compressHandler(http.HandlerFunc(h.serveFile)).ServeHTTP(rec, r)
+}
+
+func (h *dataHandler) serveFile(w http.ResponseWriter, r *http.Request) {
+	path := filepath.Join(h.dir, strings.TrimPrefix(r.URL.Path, "/"))
+	fp, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	setChecksumHeaders(w, path)
+	// counted here, before any compression, so the raw total does not
+	// depend on -compress
+	raw := &statusRecorder{ResponseWriter: w}
+	http.ServeContent(raw, r, info.Name(), info.ModTime(), fp)
+	if raw.status == http.StatusOK || raw.status == http.StatusPartialContent {
+		served.raw.Add(raw.bytes)
+	}
+}
+
+// handlerSwitch forwards to whichever handler was set last, so one server
+// can follow a sequence of jobs such as the stages of a DAG
+type handlerSwitch struct {
+	mu sync.RWMutex
+	h  http.Handler
+}
+
+func (s *handlerSwitch) set(h http.Handler) {
+	s.mu.Lock()
+	s.h = h
+	s.mu.Unlock()
+}
+
+func (s *handlerSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	h := s.h
+	s.mu.RUnlock()
+	if h == nil {
+		http.Error(w, "no job running", http.StatusServiceUnavailable)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,67 @@
+package mapreduce
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataHandlerAllowed(t *testing.T) {
+	h := &dataHandler{M: 3, R: 2}
+	for name, want := range map[string]bool{
+		"map_0_source.db":           true,
+		"map_2_source.db":           true,
+		"map_3_source.db":           false,
+		"map_2_output_1.db":         true,
+		"map_2_output_2.db":         false,
+		"combine_1_output_2.db":     true,
+		"combine_2_output_0.db":     false,
+		lookupFile:                  true,
+		sideFilePrefix + "stop.txt": true,
+		sideFilePrefix + "../x":     false,
+		"reduce_0_output.db":        false,
+		"map_0_source.db.sum":       false,
+		"../journal.db":             false,
+		"":                          false,
+	} {
+		if got := h.allowed(name); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDataHandlerRequests(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"map_0_source.db", "reduce_0_output.db"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newDataHandler(dir, 1, 1, "secret")
+
+	for _, test := range []struct {
+		method, path, auth string
+		want               int
+	}{
+		{"GET", "/map_0_source.db", "Bearer secret", http.StatusOK},
+		{"HEAD", "/map_0_source.db", "Bearer secret", http.StatusOK},
+		{"GET", "/map_0_source.db", "", http.StatusUnauthorized},
+		{"GET", "/map_0_source.db", "Bearer wrong", http.StatusUnauthorized},
+		{"GET", "/map_0_source.db", "secret", http.StatusUnauthorized},
+		{"PUT", "/map_0_source.db", "Bearer secret", http.StatusMethodNotAllowed},
+		{"GET", "/reduce_0_output.db", "Bearer secret", http.StatusNotFound},
+		{"GET", "/map_0_output_0.db", "Bearer secret", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.want {
+			t.Errorf("%s %s with %q: status %d, want %d", test.method, test.path, test.auth, rec.Code, test.want)
+		}
+	}
+}
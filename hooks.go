@@ -0,0 +1,68 @@
+package mapreduce
+
+import "log/slog"
+
+// SetupInterface is implemented by Interface implementations that hold
+// resources for the length of a task, such as a compiled pattern, a loaded
+// model or an open side database. Process calls Setup before the first
+// record and Cleanup after the last, or after Map or Reduce fails, with the
+// task number (the partition, for a combine task) and the job context. An
+// error from either fails the task without writing its output. Like
+// Configure, the hooks have to keep what they open somewhere Map and Reduce
+// can see it, so they are usually implemented on a pointer.
+type SetupInterface interface {
+	Interface
+	Setup(task int, ctx *JobContext) error
+	Cleanup(task int, ctx *JobContext) error
+}
+
+// setup runs Setup on client, or any client it wraps, and returns a
+// function that runs the matching Cleanup. The function runs Cleanup at
+// most once, so a task can both defer it and call it to check the error.
+func setup(logger *slog.Logger, client Interface, task int, ctx *JobContext) (func() error, error) {
+	si, ok := findClient[SetupInterface](client)
+	if !ok {
+		return func() error { return nil }, nil
+	}
+	if err := si.Setup(task, ctx); err != nil {
+		logger.Error("Setup", "err", err)
+		return nil, err
+	}
+	done := false
+	return func() error {
+		if done {
+			return nil
+		}
+		done = true
+		if err := si.Cleanup(task, ctx); err != nil {
+			logger.Error("Cleanup", "err", err)
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// FlushingInterface is implemented by Interface implementations that can
+// hold output back past the Map or Reduce call it came from, such as
+// Streaming, whose command writes whenever it likes. Process calls Flush
+// once the task's last Map or Reduce call has returned, before Cleanup, and
+// treats what it sends like the output of one more call. Flush closes
+// output when it is done, as Map does.
+type FlushingInterface interface {
+	Interface
+	Flush(output chan<- Pair) error
+}
+
+// flush runs Flush on client, or any client it wraps, passing the output
+// channel to collect
+func flush(logger *slog.Logger, client Interface, collect func(call func(output chan<- Pair) error) error) error {
+	fi, ok := findClient[FlushingInterface](client)
+	if !ok {
+		return nil
+	}
+	if err := collect(fi.Flush); err != nil {
+		logger.Error("Flush", "err", err)
+		return err
+	}
+	return nil
+}
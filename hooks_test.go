@@ -0,0 +1,147 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// hooked counts values, but only between Setup and Cleanup, and records
+// the tasks it was set up and cleaned up for
+type hooked struct {
+	failSetup, failCleanup, failMap bool
+
+	mu               sync.Mutex
+	open             bool
+	setups, cleanups []string
+}
+
+func (h *hooked) Setup(task int, ctx *JobContext) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.setups = append(h.setups, fmt.Sprint(ctx.Phase(), " ", task))
+	if h.failSetup {
+		return errors.New("setup failed")
+	}
+	h.open = true
+	return nil
+}
+
+func (h *hooked) Cleanup(task int, ctx *JobContext) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cleanups = append(h.cleanups, fmt.Sprint(ctx.Phase(), " ", task))
+	h.open = false
+	if h.failCleanup {
+		return errors.New("cleanup failed")
+	}
+	return nil
+}
+
+func (h *hooked) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	if !h.open {
+		return errors.New("Map called outside Setup and Cleanup")
+	}
+	if h.failMap {
+		return errInjected
+	}
+	output <- Pair{Key: value, Value: "1"}
+	return nil
+}
+
+func (h *hooked) sum(values <-chan string) (int, error) {
+	if !h.open {
+		return 0, errors.New("called outside Setup and Cleanup")
+	}
+	n := 0
+	for value := range values {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, err
+		}
+		n += i
+	}
+	return n, nil
+}
+
+func (h *hooked) Combine(key string, values <-chan string, output chan<- string) error {
+	defer close(output)
+	n, err := h.sum(values)
+	if err != nil {
+		return err
+	}
+	output <- strconv.Itoa(n)
+	return nil
+}
+
+func (h *hooked) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	n, err := h.sum(values)
+	if err != nil {
+		return err
+	}
+	output <- Pair{Key: key, Value: strconv.Itoa(n)}
+	return nil
+}
+
+func TestSetupAndCleanupAroundTasks(t *testing.T) {
+	h := &hooked{}
+	out, err := (&FakeCluster{M: 3, R: 2}).Run(WithKeyOrder(h, nil), lines(30, "w"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Pair{{Key: "w", Value: "30"}}; !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+	want := []string{"map 0", "map 1", "map 2", "reduce 0", "reduce 1"}
+	if !reflect.DeepEqual(h.setups, want) || !reflect.DeepEqual(h.cleanups, want) {
+		t.Errorf("set up %v and cleaned up %v, want %v for both", h.setups, h.cleanups, want)
+	}
+
+	for _, h := range []*hooked{{failSetup: true}, {failCleanup: true}, {failMap: true}} {
+		_, err := (&FakeCluster{M: 3, R: 2}).Run(h, lines(30, "w"))
+		if err == nil {
+			t.Errorf("%+v: job succeeded", h)
+		}
+		// a task that was set up is cleaned up even when it fails
+		cleaned := len(h.setups)
+		if h.failSetup {
+			cleaned = 0
+		}
+		if len(h.cleanups) != cleaned {
+			t.Errorf("%+v: set up %v but cleaned up %v", h, h.setups, h.cleanups)
+		}
+	}
+}
+
+func TestSetupAroundCombineTasks(t *testing.T) {
+	input := lines(2000, "hot")
+	input = append(input, lines(100, "cold")...)
+	h := &hooked{}
+	job := &Job{Client: h, M: 4, R: 4}
+	out := runJob(t, job, input)
+	if len(job.Status.Combines) == 0 {
+		t.Fatal("hot key was not salted")
+	}
+	got := make(map[string]string)
+	for _, pair := range out {
+		got[pair.Key] = pair.Value
+	}
+	if got["hot"] != "2000" || got["cold"] != "100" {
+		t.Errorf("got %v", out)
+	}
+	combines := 0
+	for _, task := range h.setups {
+		if strings.HasPrefix(task, "combine ") {
+			combines++
+		}
+	}
+	if combines != len(job.Status.Combines) || len(h.cleanups) != len(h.setups) {
+		t.Errorf("set up %v and cleaned up %v for %d combine tasks", h.setups, h.cleanups, len(job.Status.Combines))
+	}
+}
@@ -0,0 +1,255 @@
+package mapreduce
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Iterative reruns the same job, feeding each iteration's reduce outputs
+// back in as the next iteration's sources, for algorithms like PageRank
+// or k-means that repeat until the answer settles.
+type Iterative struct {
+	ID      string
+	Dir     string // iteration n runs in Dir/iter_n
+	Address string // host:port where workers reach this node's /data/ handler
+	Client  Interface
+	M, R    int
+	Sources []string // inputs to the first iteration
+
+	TotalOrder    bool     // see Job.TotalOrder
+	CoalesceBytes int64    // see Job.CoalesceBytes
+	Lookup        string   // see Job.Lookup
+	Files         []string // see Job.Files
+	Conf          JobConf  // see Job.Conf
+	Spec          *JobSpec // see Job.Spec
+
+	MaxIterations int // stop after this many even if not converged; must be at least 1
+	Keep          int // how many of the latest iterations to keep on disk; 0 means 1
+
+	// Converged is called after every iteration with the reduce outputs of
+	// this iteration and the one before it (nil after the first) and the
+	// iteration's summed counters. Returning true stops the run.
+	Converged func(iteration int, current, previous []string, counters *Counters) (bool, error)
+
+	// if Counter is set, the run also stops once that counter's total for
+	// an iteration is at or below Threshold
+	Counter   string
+	Threshold int64
+
+	Data    *handlerSwitch // pointed at the running iteration's data handler
+	Status  *handlerSwitch // pointed at the running iteration's status; may be nil
+	Journal *journal       // lets a rerun skip finished iterations; may be nil
+}
+
+func (it *Iterative) iterationDir(n int) string {
+	return filepath.Join(it.Dir, fmt.Sprintf("iter_%d", n))
+}
+
+func (it *Iterative) iterationID(n int) string {
+	return fmt.Sprintf("%s.iter_%d", it.ID, n)
+}
+
+func (it *Iterative) iteration(n int, sources []string) *Job {
+	return &Job{
+		ID:      it.iterationID(n),
+		Sources: sources,
+		M:       it.M,
+		R:       it.R,
+		TempDir: it.iterationDir(n),
+		Address: it.Address,
+		Client:  it.Client,
+		Status:  newJobStatus(fmt.Sprintf("iteration %d", n), it.M, it.R),
+		Journal: it.Journal,
+
+		TotalOrder:    it.TotalOrder,
+		CoalesceBytes: it.CoalesceBytes,
+		Lookup:        it.Lookup,
+		Files:         it.Files,
+		Conf:          it.Conf,
+		Spec:          it.Spec,
+	}
+}
+
+// intactIteration returns the outputs of iteration n if the journal says
+// it finished and they are all still in place, or nil
+func (it *Iterative) intactIteration(n int) ([]string, error) {
+	id := it.iterationID(n)
+	state, err := it.Journal.jobState(id)
+	if err != nil || state != "done" {
+		return nil, err
+	}
+	outputs, err := it.Journal.outputs(id, it.iteration(n, nil).outputPhase())
+	if err != nil || len(outputs) == 0 || !outputsIntact(outputs) {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// lastJournaled returns the number of the last iteration the journal has
+// heard of, or 0
+func (it *Iterative) lastJournaled() (int, error) {
+	last := 0
+	for n := 1; n <= it.MaxIterations; n++ {
+		state, err := it.Journal.jobState(it.iterationID(n))
+		if err != nil {
+			return 0, err
+		}
+		if state == "" {
+			break
+		}
+		last = n
+	}
+	return last, nil
+}
+
+// resume works out where a rerun picks up from the journal: the newest
+// iteration that finished with its outputs intact, or 0 for none. Older
+// iterations may have been removed as Keep allows. Journaled iterations
+// after it are forgotten so they run again, except one that was still
+// running, which resumes.
+func (it *Iterative) resume() (int, error) {
+	last, err := it.lastJournaled()
+	if err != nil {
+		return 0, err
+	}
+	newest := 0
+	for n := last; n >= 1 && newest == 0; n-- {
+		outputs, err := it.intactIteration(n)
+		if err != nil {
+			return 0, err
+		}
+		if outputs != nil {
+			newest = n
+		}
+	}
+	for n := newest + 1; n <= last; n++ {
+		state, err := it.Journal.jobState(it.iterationID(n))
+		if err != nil {
+			return 0, err
+		}
+		if n == newest+1 && state == "running" {
+			continue
+		}
+		slog.Info("running iteration again", "iteration", n)
+		if err := it.Journal.forgetJob(it.iterationID(n)); err != nil {
+			return 0, err
+		}
+	}
+	return newest, nil
+}
+
+// Run iterates until convergence or MaxIterations and returns the final
+// reduce outputs and the number of iterations run. With a Journal, a rerun
+// picks up after the newest iteration that finished, with its counters
+// restored, and a rerun of a finished job returns its result.
+func (it *Iterative) Run() ([]string, int, error) {
+	if it.MaxIterations < 1 {
+		return nil, 0, fmt.Errorf("iterative job needs MaxIterations of at least 1")
+	}
+	keep := it.Keep
+	if keep < 1 {
+		keep = 1
+	}
+
+	first := 1
+	checked := false // whether the first iteration's convergence check already ran
+	sources := it.Sources
+	var previous []string
+	if it.Journal != nil {
+		state, err := it.Journal.jobState(it.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch state {
+		case "":
+			job := &Job{ID: it.ID, Sources: it.Sources, M: it.M, R: it.R, TempDir: it.Dir}
+			if err := it.Journal.createJob(job); err != nil {
+				return nil, 0, err
+			}
+		case "done":
+			last, err := it.lastJournaled()
+			if err != nil {
+				return nil, 0, err
+			}
+			outputs, err := it.intactIteration(last)
+			if err != nil {
+				return nil, last, err
+			}
+			if last == 0 || outputs == nil {
+				return nil, last, fmt.Errorf("journal says %s finished but its outputs are missing or damaged", it.ID)
+			}
+			slog.Info("iterative job already finished", "iterations", last)
+			return outputs, last, nil
+		}
+		newest, err := it.resume()
+		if err != nil {
+			return nil, 0, err
+		}
+		if newest > 0 {
+			slog.Info("resuming after iteration", "iteration", newest)
+			first = newest
+			// the outputs before it are only removed once its check has
+			// run, and they are the previous outputs that check needs
+			if newest > 1 {
+				if previous, err = it.intactIteration(newest - 1); err != nil {
+					return nil, 0, err
+				}
+				checked = previous == nil
+			}
+		}
+	}
+
+	for n := first; n <= it.MaxIterations; n++ {
+		job := it.iteration(n, sources)
+		if err := runStage(job, it.Data, it.Status); err != nil {
+			return nil, n, fmt.Errorf("iteration %d: %w", n, err)
+		}
+
+		current := job.Outputs()
+		counters := job.Status.Counters
+
+		// a run before this one may have checked the iteration it resumes
+		// after and gone on
+		done := false
+		if n > first || !checked {
+			if it.Counter != "" && counters.Get(it.Counter) <= it.Threshold {
+				slog.Info("counter reached threshold", "iteration", n, "counter", it.Counter, "value", counters.Get(it.Counter), "threshold", it.Threshold)
+				done = true
+			}
+			if !done && it.Converged != nil {
+				converged, err := it.Converged(n, current, previous, counters)
+				if err != nil {
+					return nil, n, fmt.Errorf("checking convergence after iteration %d: %w", n, err)
+				}
+				done = converged
+			}
+		}
+
+		// only the latest iterations are kept; the one just finished is
+		// always among them, and the one before survives until the
+		// convergence check above has compared against it
+		if old := n - keep; old >= 1 {
+			if err := os.RemoveAll(it.iterationDir(old)); err != nil {
+				slog.Warn("removing old iteration", "file", it.iterationDir(old), "err", err)
+			}
+		}
+
+		if done {
+			slog.Info("converged", "iteration", n)
+			return current, n, it.finish()
+		}
+		previous = current
+		sources = current
+	}
+	slog.Warn("stopped before converging", "iterations", it.MaxIterations)
+	return previous, it.MaxIterations, it.finish()
+}
+
+func (it *Iterative) finish() error {
+	if it.Journal != nil {
+		return it.Journal.finishJob(it.ID)
+	}
+	return nil
+}
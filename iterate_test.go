@@ -0,0 +1,224 @@
+package mapreduce
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// divide divides every value by the job parameter "by" each iteration and
+// counts the keys whose value is not yet zero
+type divide struct {
+	by   int
+	fail bool // Map fails while set
+	ctx  *JobContext
+}
+
+func (d *divide) Configure(conf JobConf) (err error) {
+	d.by, err = conf.Int("by", 2)
+	return err
+}
+
+func (d *divide) SetContext(ctx *JobContext) {
+	d.ctx = ctx
+}
+
+func (d *divide) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	if d.fail {
+		return errInjected
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	output <- Pair{Key: key, Value: strconv.Itoa(v / d.by)}
+	return nil
+}
+
+func (d *divide) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	sum := 0
+	for value := range values {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		sum += v
+	}
+	if sum > 0 {
+		d.ctx.Counters().Add("nonzero", 1)
+	}
+	output <- Pair{Key: key, Value: strconv.Itoa(sum)}
+	return nil
+}
+
+// writeSource writes pairs into a source database in dir
+func writeSource(t *testing.T, dir string, pairs []Pair) string {
+	t.Helper()
+	path := filepath.Join(dir, "source.db")
+	if err := writePairs(path, pairs); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func numbers(n, start int) []Pair {
+	var pairs []Pair
+	for i := 0; i < n; i++ {
+		pairs = append(pairs, Pair{Key: fmt.Sprintf("k%02d", i), Value: strconv.Itoa(start + i)})
+	}
+	return pairs
+}
+
+func TestIterativeStopsAtCounterThreshold(t *testing.T) {
+	dir := t.TempDir()
+	it := &Iterative{
+		ID: "divide", Dir: filepath.Join(dir, "it"), Client: &divide{}, M: 3, R: 2,
+		Sources:       []string{writeSource(t, dir, numbers(20, 1000))},
+		MaxIterations: 10, Counter: "nonzero",
+		TotalOrder: true, Conf: JobConf{"by": "10"},
+	}
+	out, n, err := (&FakeCluster{}).RunIterative(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1019 takes four divisions by ten to reach zero
+	if n != 4 {
+		t.Errorf("ran %d iterations, want 4", n)
+	}
+	if len(out) != 20 {
+		t.Fatalf("got %d pairs, want 20", len(out))
+	}
+	if !sort.SliceIsSorted(out, func(a, b int) bool { return out[a].Key < out[b].Key }) {
+		t.Errorf("total order outputs are not sorted: %v", out)
+	}
+	for _, pair := range out {
+		if pair.Value != "0" {
+			t.Errorf("%s is %s after converging, want 0", pair.Key, pair.Value)
+		}
+	}
+}
+
+func TestIterativeConverged(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	it := &Iterative{
+		ID: "divide", Dir: filepath.Join(dir, "it"), Client: &divide{}, M: 2, R: 2,
+		Sources:       []string{writeSource(t, dir, numbers(5, 64))},
+		MaxIterations: 10, Keep: 2,
+		Converged: func(n int, current, previous []string, counters *Counters) (bool, error) {
+			calls++
+			if (n == 1) != (previous == nil) {
+				t.Errorf("iteration %d: previous outputs %v", n, previous)
+			}
+			if !outputsIntact(previous) {
+				t.Errorf("iteration %d: previous outputs were removed before the check", n)
+			}
+			return n == 3, nil
+		},
+	}
+	out, n, err := (&FakeCluster{}).RunIterative(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || calls != 3 {
+		t.Errorf("stopped after %d iterations and %d checks, want 3 of each", n, calls)
+	}
+	// 64..68 halved three times
+	for _, pair := range out {
+		if pair.Value != "8" {
+			t.Errorf("%s is %s, want 8", pair.Key, pair.Value)
+		}
+	}
+}
+
+func TestIterativeResume(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := openJournal(filepath.Join(dir, "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	source := writeSource(t, dir, numbers(10, 1000))
+
+	client := &divide{}
+	var checks []int
+	counts := make(map[int]int64)
+	stop := 0 // fail the check after this iteration, or the map tasks after it
+	failMaps := false
+	newIterative := func(id string) *Iterative {
+		return &Iterative{
+			ID: id, Dir: filepath.Join(dir, id), Client: client, M: 2, R: 2,
+			Sources: []string{source}, MaxIterations: 20, Counter: "nonzero",
+			Journal: journal,
+			Converged: func(n int, current, previous []string, counters *Counters) (bool, error) {
+				checks = append(checks, n)
+				if c, ok := counts[n]; ok && c != counters.Get("nonzero") {
+					t.Errorf("iteration %d: counter is %d, was %d", n, counters.Get("nonzero"), c)
+				}
+				counts[n] = counters.Get("nonzero")
+				if n == stop {
+					if failMaps {
+						client.fail = true
+						return false, nil
+					}
+					return false, fmt.Errorf("stopping after iteration %d", n)
+				}
+				return false, nil
+			},
+		}
+	}
+
+	want, wantN, err := (&FakeCluster{}).RunIterative(newIterative("whole"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stopped in the check of iteration 5, with iteration 4 removed
+	checks, stop = nil, 5
+	if _, _, err := (&FakeCluster{}).RunIterative(newIterative("check")); err == nil {
+		t.Fatal("stopped run succeeded")
+	}
+	checks, stop = nil, 0
+	got, n, err := (&FakeCluster{}).RunIterative(newIterative("check"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != wantN || !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed run gave %v after %d iterations, want %v after %d", got, n, want, wantN)
+	}
+	if len(checks) == 0 || checks[0] != 5 {
+		t.Errorf("resumed run checked iterations %v, want to start at 5", checks)
+	}
+
+	// failed in iteration 4 once the check of iteration 3 had gone on and
+	// removed iteration 2
+	counts = make(map[int]int64)
+	checks, stop, failMaps = nil, 3, true
+	if _, _, err := (&FakeCluster{}).RunIterative(newIterative("maps")); err == nil {
+		t.Fatal("failing run succeeded")
+	}
+	checks, stop, client.fail = nil, 0, false
+	if got, n, err = (&FakeCluster{}).RunIterative(newIterative("maps")); err != nil {
+		t.Fatal(err)
+	}
+	if n != wantN || !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed run gave %v after %d iterations, want %v after %d", got, n, want, wantN)
+	}
+	if len(checks) == 0 || checks[0] != 4 {
+		t.Errorf("resumed run checked iterations %v, want to start at 4", checks)
+	}
+
+	// a finished run is not run again
+	checks = nil
+	if got, n, err = (&FakeCluster{}).RunIterative(newIterative("maps")); err != nil {
+		t.Fatal(err)
+	}
+	if n != wantN || !reflect.DeepEqual(got, want) || len(checks) != 0 {
+		t.Errorf("rerun of a finished job gave %v after %d iterations and checked %v", got, n, checks)
+	}
+}
@@ -0,0 +1,270 @@
+package mapreduce
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Job is one map/reduce run over a source database, driven from this node
+type Job struct {
+	ID      string
+	Sources []string // pairs databases split into the map inputs
+	M, R    int      // number of map and reduce tasks
+	TempDir string   // splits, intermediate files and outputs
+	Address string   // host:port where other workers reach this node's /data/ handler
+
+	// the join side each of Sources belongs to, when several of them are
+	// one input, such as the outputs of a DAG stage's parent; nil gives
+	// every source a side of its own
+	SourceSides []int
+
+	// partition map output by sampled key ranges, so the reduce outputs
+	// read in order are globally sorted
+	TotalOrder bool
+
+	// merge neighbouring partitions smaller than this many bytes of map
+	// output into one reduce task; 0 gives every partition its own
+	CoalesceBytes int64
+
+	// small pairs database every map task loads into memory; see Lookup
+	Lookup string
+
+	// files copied to every worker before its tasks run; user code finds
+	// them by base name through JobContext.File
+	Files []string
+
+	// parameters handed to the client's Configure method before every task
+	Conf JobConf
+
+	Client  Interface
+	Status  *jobStatus
+	Journal *journal // records progress for resume; nil runs without one
+
+	// the settings the job was built from, journaled so a resume can
+	// rebuild it; nil for a job set up in code
+	Spec *JobSpec
+
+	// filled in as tasks finish: the main output file of each reduce task
+	// (each map task for a map-only job) and the files of named outputs
+	outputs []string
+	named   map[string][]string
+}
+
+// Outputs returns the main reduce output files of a finished job in order.
+// There are fewer than R of them when partitions were coalesced, and a
+// map-only job (R of 0) has one per map task.
+func (job *Job) Outputs() []string {
+	return job.outputs
+}
+
+// source names the job's inputs for logs and the status page
+func (job *Job) source() string {
+	return strings.Join(job.Sources, ", ")
+}
+
+// split divides the source into M map inputs, unless the journal shows
+// that already happened
+func (job *Job) split() error {
+	if job.Journal != nil {
+		paths, err := job.Journal.splits(job.ID, job.M)
+		if err != nil {
+			return err
+		}
+		if paths != nil && outputsIntact(paths) {
+			slog.Info("reusing splits from journal", "file", job.source(), "m", job.M)
+			return nil
+		}
+	}
+
+	slog.Info("splitting source", "file", job.source(), "m", job.M, "r", job.R)
+	paths := createPaths(job.M, mapSource, job.TempDir)
+	var sides []int
+	if _, tagged := findClient[sourceTagger](job.Client); tagged {
+		sides = job.sides()
+	}
+	if err := splitDatabases(job.Sources, paths, sides); err != nil {
+		return err
+	}
+	if job.Journal != nil {
+		return job.Journal.recordSplits(job.ID, paths)
+	}
+	return nil
+}
+
+// sides returns the join side of each of the job's sources
+func (job *Job) sides() []int {
+	if job.SourceSides != nil {
+		return job.SourceSides
+	}
+	sides := make([]int, len(job.Sources))
+	for i := range sides {
+		sides[i] = i
+	}
+	return sides
+}
+
+// bounds returns the range bounds for a total-order job, sampling the
+// splits unless the journal already has them. Other jobs get nil.
+func (job *Job) bounds(files []SideFile) ([]string, error) {
+	if !job.TotalOrder || job.R < 2 {
+		return nil, nil
+	}
+	if job.Journal != nil {
+		bounds, err := job.Journal.bounds(job.ID, job.R)
+		if err != nil || bounds != nil {
+			return bounds, err
+		}
+	}
+	// the driver fetches the side files from itself like any worker
+	ctx, err := fetchSideFiles(slog.Default(), new(taskTrace), job.Address, job.TempDir, files)
+	if err != nil {
+		return nil, err
+	}
+	ctx.phase, ctx.conf = "sample", job.Conf
+	bounds, err := sampleBounds(createPaths(job.M, mapSource, job.TempDir), job.Client, ctx, job.R)
+	if err != nil {
+		return nil, err
+	}
+	if job.Journal != nil {
+		return bounds, job.Journal.recordBounds(job.ID, bounds)
+	}
+	return bounds, nil
+}
+
+// finished returns the tasks of a phase the journal says are already done
+func (job *Job) finished(phase string) (map[int]finishedTask, error) {
+	if job.Journal == nil {
+		return nil, nil
+	}
+	return job.Journal.finishedTasks(job.ID, phase)
+}
+
+func (job *Job) record(phase string, n int, outputs []string, stats TaskStats) error {
+	if job.Journal == nil {
+		return nil
+	}
+	return job.Journal.recordTask(job.ID, phase, n, job.Address, outputs, stats.Counters)
+}
+
+// Run processes every map task, then lays out the reduce tasks from what
+// the maps wrote and runs any combine tasks for hot keys, then every reduce
+// task. Map and reduce tasks the journal already has finished output for
+// are skipped.
+func (job *Job) Run() error {
+	if err := job.split(); err != nil {
+		return err
+	}
+	lookupHost := ""
+	if job.Lookup != "" {
+		if err := job.prepareLookup(); err != nil {
+			return err
+		}
+		lookupHost = job.Address
+	}
+	files, err := job.prepareFiles()
+	if err != nil {
+		return err
+	}
+	bounds, err := job.bounds(files)
+	if err != nil {
+		return err
+	}
+
+	job.outputs, job.named = nil, nil
+	mapHosts := make([]string, job.M)
+	doneMaps, err := job.finished("map")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < job.M; i++ {
+		previous, done := doneMaps[i]
+		worker := previous.Worker
+		outputs := previous.Outputs
+		if done {
+			job.Status.restore("map", i, previous)
+		} else {
+			task := &MapTask{
+				M:          job.M,
+				R:          job.R,
+				N:          i,
+				SourceHost: job.Address,
+				Worker:     job.Address,
+				Bounds:     bounds,
+				LookupHost: lookupHost,
+				FilesHost:  job.Address,
+				Files:      files,
+				Conf:       job.Conf,
+			}
+			task.Attempt = job.Status.start("map", i, job.Address)
+			err := task.Process(job.TempDir, job.Client)
+			job.Status.finish("map", i, task.Stats, err)
+			if err != nil {
+				return fmt.Errorf("map task %d: %w", i, err)
+			}
+
+			outputs = task.Outputs
+			if err := job.record("map", i, outputs, task.Stats); err != nil {
+				return err
+			}
+			worker = job.Address
+		}
+		mapHosts[i] = worker
+		if job.R == 0 {
+			job.addOutputs(outputs)
+		}
+	}
+	slog.Info("processed all of map tasks", "phase", "map")
+	if job.R == 0 {
+		return job.finish()
+	}
+
+	reduceTasks, skew, err := job.planReduces(mapHosts)
+	if err != nil {
+		return err
+	}
+	doneReduces, err := job.finished("reduce")
+	if err != nil {
+		return err
+	}
+	for _, task := range reduceTasks {
+		task.FilesHost, task.Files, task.Conf = job.Address, files, job.Conf
+	}
+	if err := job.runCombines(reduceTasks, skew, doneReduces); err != nil {
+		return err
+	}
+	for i, task := range reduceTasks {
+		if previous, done := doneReduces[i]; done {
+			job.Status.restore("reduce", i, previous)
+			job.addOutputs(previous.Outputs)
+			continue
+		}
+		task.Attempt = job.Status.start("reduce", i, job.Address)
+		err := task.Process(job.TempDir, job.Client)
+		job.Status.finish("reduce", i, task.Stats, err)
+		if err != nil {
+			return fmt.Errorf("reduce task %d: %w", i, err)
+		}
+		if err := job.record("reduce", i, task.Outputs, task.Stats); err != nil {
+			return err
+		}
+		job.addOutputs(task.Outputs)
+	}
+	slog.Info("processed all of reduce tasks", "phase", "reduce")
+	return job.finish()
+}
+
+func (job *Job) finish() error {
+	if job.Journal != nil {
+		return job.Journal.finishJob(job.ID)
+	}
+	return nil
+}
+
+// outputPhase is the phase whose tasks write the job's final output
+func (job *Job) outputPhase() string {
+	if job.R == 0 {
+		return "map"
+	}
+	return "reduce"
+}
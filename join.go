@@ -0,0 +1,143 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type JoinKind int
+
+const (
+	InnerJoin     JoinKind = iota // keys found in every source
+	LeftOuterJoin                 // every key of the first source
+	FullOuterJoin                 // every key of any source
+)
+
+func parseJoinKind(name string) (JoinKind, error) {
+	switch name {
+	case "inner":
+		return InnerJoin, nil
+	case "left":
+		return LeftOuterJoin, nil
+	case "full":
+		return FullOuterJoin, nil
+	}
+	return 0, fmt.Errorf("unknown join kind %q", name)
+}
+
+// Join is an Interface that joins a job's inputs on key. The split tags
+// every record with the side it came from: one of Job.Sources, or for a
+// DAG stage one of its sources or parents. Map passes records through and
+// Reduce pairs up the values each side has for a key. Every combination is
+// written as one pair whose value is a JSON array holding a value from each
+// side in order, with null for a side an outer join found no match in.
+// DecodeJoined reads it back. Use it through a pointer, so SetContext can
+// hand it the task's counters.
+type Join struct {
+	Kind  JoinKind
+	Sides int // number of sides joined; 0 means 2
+
+	ctx *JobContext // the running task's, from SetContext
+}
+
+func (j *Join) tagsSources() {}
+
+func (j *Join) SetContext(ctx *JobContext) {
+	j.ctx = ctx
+}
+
+func (j *Join) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	if _, _, err := untagValue(value); err != nil {
+		return err
+	}
+	output <- Pair{Key: key, Value: value}
+	return nil
+}
+
+func (j *Join) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	sides := j.Sides
+	if sides == 0 {
+		sides = 2
+	}
+	bySide := make([][]*string, sides)
+	for tagged := range values {
+		side, value, err := untagValue(tagged)
+		if err != nil {
+			return err
+		}
+		if side >= sides {
+			return fmt.Errorf("value for key %q comes from side %d of a %d-way join", key, side, sides)
+		}
+		bySide[side] = append(bySide[side], &value)
+	}
+
+	for side, list := range bySide {
+		if len(list) > 0 {
+			continue
+		}
+		if j.Kind == InnerJoin || (j.Kind == LeftOuterJoin && side == 0) {
+			j.ctx.Counters().Add("keys without a match", 1)
+			return nil
+		}
+		bySide[side] = []*string{nil}
+	}
+
+	// every combination of one value from each side
+	row := make([]*string, sides)
+	var emit func(side int) error
+	emit = func(side int) error {
+		if side == sides {
+			encoded, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+			output <- Pair{Key: key, Value: string(encoded)}
+			j.ctx.Counters().Add("joined rows", 1)
+			return nil
+		}
+		for _, value := range bySide[side] {
+			row[side] = value
+			if err := emit(side + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return emit(0)
+}
+
+// DecodeJoined splits a joined value into one value per source; nil marks
+// a source with no match
+func DecodeJoined(value string) ([]*string, error) {
+	var row []*string
+	if err := json.Unmarshal([]byte(value), &row); err != nil {
+		return nil, fmt.Errorf("decoding joined value %q: %w", value, err)
+	}
+	return row, nil
+}
+
+// a client that wants each record's value prefixed with the number of the
+// source it was split from
+type sourceTagger interface {
+	tagsSources()
+}
+
+func tagPrefix(source int) string {
+	return strconv.Itoa(source) + ":"
+}
+
+func untagValue(tagged string) (int, string, error) {
+	tag, value, ok := strings.Cut(tagged, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("value %q has no source tag", tagged)
+	}
+	source, err := strconv.Atoi(tag)
+	if err != nil {
+		return 0, "", fmt.Errorf("value %q has a bad source tag: %w", tagged, err)
+	}
+	return source, value, nil
+}
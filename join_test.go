@@ -0,0 +1,115 @@
+package mapreduce
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// decodedRows turns joined pairs back into key and per-source values, with
+// "-" for a missing match, sorted
+func decodedRows(t *testing.T, pairs []Pair) [][]string {
+	t.Helper()
+	var rows [][]string
+	for _, pair := range pairs {
+		values, err := DecodeJoined(pair.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := []string{pair.Key}
+		for _, value := range values {
+			if value == nil {
+				row = append(row, "-")
+			} else {
+				row = append(row, *value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(a, b int) bool {
+		for i := range rows[a] {
+			if rows[a][i] != rows[b][i] {
+				return rows[a][i] < rows[b][i]
+			}
+		}
+		return false
+	})
+	return rows
+}
+
+func TestJoinKinds(t *testing.T) {
+	dir := t.TempDir()
+	var sources []string
+	for i, pairs := range [][]Pair{
+		{{Key: "ann", Value: "london"}, {Key: "bob", Value: "paris"}, {Key: "cy", Value: "rome"}},
+		{{Key: "ann", Value: "tea"}, {Key: "ann", Value: "cake"}, {Key: "bob", Value: "wine"}, {Key: "dee", Value: "beer"}},
+	} {
+		sub := filepath.Join(dir, string(rune('a'+i)))
+		if err := os.Mkdir(sub, 0700); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, writeSource(t, sub, pairs))
+	}
+
+	for kind, want := range map[string][][]string{
+		"inner": {{"ann", "london", "cake"}, {"ann", "london", "tea"}, {"bob", "paris", "wine"}},
+		"left":  {{"ann", "london", "cake"}, {"ann", "london", "tea"}, {"bob", "paris", "wine"}, {"cy", "rome", "-"}},
+		"full":  {{"ann", "london", "cake"}, {"ann", "london", "tea"}, {"bob", "paris", "wine"}, {"cy", "rome", "-"}, {"dee", "-", "beer"}},
+	} {
+		spec := &JobSpec{Sources: sources, Join: kind}
+		client, err := spec.client()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &DAG{ID: "join", Dir: filepath.Join(dir, kind), Stages: []*Stage{
+			{Name: "join", Client: client, M: 2, R: 2, Sources: sources},
+		}}
+		out, err := (&FakeCluster{}).RunDAG(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decodedRows(t, out["join"]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s join gave %v, want %v", kind, got, want)
+		}
+	}
+
+	if _, err := (&JobSpec{Sources: sources, Join: "cross"}).client(); err == nil {
+		t.Error("unknown join kind accepted")
+	}
+	if _, err := (&JobSpec{Sources: sources, Join: "inner", Mapper: "cat"}).client(); err == nil {
+		t.Error("join with a mapper accepted")
+	}
+}
+
+func TestJoinParentStage(t *testing.T) {
+	dir := t.TempDir()
+	for name, pairs := range map[string][]Pair{
+		"text":   {{Key: "line 1", Value: "ann bob cy"}, {Key: "line 2", Value: "ann dee eve fay"}},
+		"people": {{Key: "ann", Value: "london"}, {Key: "dee", Value: "oslo"}, {Key: "fay", Value: "paris"}, {Key: "gus", Value: "rome"}},
+	} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		writeSource(t, filepath.Join(dir, name), pairs)
+	}
+
+	// the parent's two outputs are one side of the join, after the source
+	f := &SpecFile{ID: "parent", Stages: []*StageSpec{
+		{Name: "count", M: 2, R: 2, JobSpec: JobSpec{Sources: []string{filepath.Join(dir, "text", "source.db")}}},
+		{Name: "join", M: 2, R: 2, Parents: []string{"count"}, JobSpec: JobSpec{Sources: []string{filepath.Join(dir, "people", "source.db")}, Join: "left"}},
+	}}
+	d, err := f.dag(filepath.Join(dir, "run"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := (&FakeCluster{}).RunDAG(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"ann", "london", "2"}, {"dee", "oslo", "1"}, {"fay", "paris", "1"}, {"gus", "rome", "-"}}
+	if got := decodedRows(t, out["join"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,461 @@
+package mapreduce
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// journal records a job's layout and which of its tasks have finished, so
+// a driver that dies part way through can pick up where it left off
+type journal struct {
+	db *sql.DB
+}
+
+var errNoSuchJob = errors.New("no such job in journal")
+
+func openJournal(path string) (*journal, error) {
+	options :=
+		"?" + "_busy_timeout=10000" +
+			"&" + "_foreign_keys=ON" +
+			"&" + "_journal_mode=WAL" +
+			"&" + "_synchronous=NORMAL"
+	db, err := sql.Open("sqlite3", path+options)
+	if err != nil {
+		slog.Error("opening journal", "file", path, "err", err)
+		return nil, err
+	}
+	schema := []string{
+		`create table if not exists jobs (
+			id text primary key,
+			source text not null,
+			m integer not null,
+			r integer not null,
+			tempdir text not null,
+			state text not null,
+			spec text not null default ''
+		)`,
+		`create table if not exists splits (
+			job_id text not null references jobs (id),
+			n integer not null,
+			path text not null,
+			primary key (job_id, n)
+		)`,
+		`create table if not exists bounds (
+			job_id text not null references jobs (id),
+			n integer not null,
+			key text not null,
+			primary key (job_id, n)
+		)`,
+		`create table if not exists reduces (
+			job_id text not null references jobs (id),
+			n integer not null,
+			partitions text not null,
+			primary key (job_id, n)
+		)`,
+		`create table if not exists tasks (
+			job_id text not null references jobs (id),
+			phase text not null,
+			n integer not null,
+			worker text not null,
+			outputs text not null,
+			counters text not null,
+			primary key (job_id, phase, n)
+		)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			slog.Error("creating journal tables", "file", path, "err", err)
+			db.Close()
+			return nil, err
+		}
+	}
+	// journals from before job settings were recorded lack the column
+	var specs int
+	if err := db.QueryRow("select count(*) from pragma_table_info('jobs') where name = 'spec'").Scan(&specs); err != nil {
+		slog.Error("reading journal tables", "file", path, "err", err)
+		db.Close()
+		return nil, err
+	}
+	if specs == 0 {
+		if _, err := db.Exec("alter table jobs add column spec text not null default ''"); err != nil {
+			slog.Error("adding job settings to journal", "file", path, "err", err)
+			db.Close()
+			return nil, err
+		}
+	}
+	return &journal{db: db}, nil
+}
+
+func (j *journal) Close() error {
+	return j.db.Close()
+}
+
+func (j *journal) createJob(job *Job) error {
+	spec := ""
+	if job.Spec != nil {
+		encoded, err := json.Marshal(job.Spec)
+		if err != nil {
+			return err
+		}
+		spec = string(encoded)
+	}
+	_, err := j.db.Exec("insert into jobs (id, source, m, r, tempdir, state, spec) values (?, ?, ?, ?, ?, ?, ?)",
+		job.ID, strings.Join(job.Sources, "\n"), job.M, job.R, job.TempDir, "running", spec)
+	if err != nil {
+		slog.Error("recording job in journal", "err", err)
+	}
+	return err
+}
+
+// loadJob fills in the layout of a journaled job and the settings it was
+// built from, if they were recorded
+func (j *journal) loadJob(id string) (*Job, error) {
+	job := &Job{ID: id}
+	var sources, state, spec string
+	err := j.db.QueryRow("select source, m, r, tempdir, state, spec from jobs where id = ?", id).
+		Scan(&sources, &job.M, &job.R, &job.TempDir, &state, &spec)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", errNoSuchJob, id)
+	}
+	if err != nil {
+		slog.Error("loading job from journal", "err", err)
+		return nil, err
+	}
+	if state == "done" {
+		return nil, fmt.Errorf("job %s already finished", id)
+	}
+	job.Sources = strings.Split(sources, "\n")
+	if job.Spec, err = j.spec(id); err != nil {
+		return nil, err
+	}
+	bounds, err := j.bounds(id, job.R)
+	if err != nil {
+		return nil, err
+	}
+	job.TotalOrder = bounds != nil
+	return job, nil
+}
+
+// spec returns the settings a journaled job was built from, or nil if
+// none were recorded
+func (j *journal) spec(id string) (*JobSpec, error) {
+	var encoded string
+	err := j.db.QueryRow("select spec from jobs where id = ?", id).Scan(&encoded)
+	if err == sql.ErrNoRows || err == nil && encoded == "" {
+		return nil, nil
+	}
+	if err != nil {
+		slog.Error("reading job settings from journal", "err", err)
+		return nil, err
+	}
+	spec := new(JobSpec)
+	if err := json.Unmarshal([]byte(encoded), spec); err != nil {
+		return nil, fmt.Errorf("decoding settings of job %s from journal: %w", id, err)
+	}
+	return spec, nil
+}
+
+// jobState returns "running" or "done" for a journaled job, or "" if the
+// journal has never seen it
+func (j *journal) jobState(id string) (string, error) {
+	var state string
+	err := j.db.QueryRow("select state from jobs where id = ?", id).Scan(&state)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		slog.Error("reading job state from journal", "err", err)
+		return "", err
+	}
+	return state, nil
+}
+
+func (j *journal) finishJob(id string) error {
+	_, err := j.db.Exec("update jobs set state = 'done' where id = ?", id)
+	if err != nil {
+		slog.Error("marking job done in journal", "err", err)
+	}
+	return err
+}
+
+func (j *journal) recordSplits(id string, paths []string) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	for n, path := range paths {
+		if _, err := tx.Exec("insert or replace into splits (job_id, n, path) values (?, ?, ?)", id, n, path); err != nil {
+			slog.Error("recording split in journal", "err", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// splits returns the recorded split files, or nil if the split never finished
+func (j *journal) splits(id string, m int) ([]string, error) {
+	rows, err := j.db.Query("select path from splits where job_id = ? order by n", id)
+	if err != nil {
+		slog.Error("reading splits from journal", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(paths) != m {
+		return nil, nil
+	}
+	return paths, nil
+}
+
+func (j *journal) recordBounds(id string, bounds []string) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	for n, key := range bounds {
+		if _, err := tx.Exec("insert or replace into bounds (job_id, n, key) values (?, ?, ?)", id, n, key); err != nil {
+			slog.Error("recording range bound in journal", "err", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// bounds returns the range bounds of a total-order job, or nil if none
+// were recorded
+func (j *journal) bounds(id string, r int) ([]string, error) {
+	rows, err := j.db.Query("select key from bounds where job_id = ? order by n", id)
+	if err != nil {
+		slog.Error("reading range bounds from journal", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var bounds []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if r < 2 || len(bounds) != r-1 {
+		return nil, nil
+	}
+	return bounds, nil
+}
+
+// recordReduceLayout records which partitions each reduce task covers
+func (j *journal) recordReduceLayout(id string, groups [][]int) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	for n, group := range groups {
+		var partitions []string
+		for _, p := range group {
+			partitions = append(partitions, strconv.Itoa(p))
+		}
+		if _, err := tx.Exec("insert or replace into reduces (job_id, n, partitions) values (?, ?, ?)", id, n, strings.Join(partitions, ",")); err != nil {
+			slog.Error("recording reduce layout in journal", "err", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// reduceLayout returns the partitions each reduce task covers, or nil if
+// no layout covering all r partitions was recorded
+func (j *journal) reduceLayout(id string, r int) ([][]int, error) {
+	rows, err := j.db.Query("select partitions from reduces where job_id = ? order by n", id)
+	if err != nil {
+		slog.Error("reading reduce layout from journal", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var groups [][]int
+	covered := 0
+	for rows.Next() {
+		var partitions string
+		if err := rows.Scan(&partitions); err != nil {
+			return nil, err
+		}
+		var group []int
+		for _, field := range strings.Split(partitions, ",") {
+			p, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("bad reduce layout in journal for %s: %w", id, err)
+			}
+			group = append(group, p)
+		}
+		covered += len(group)
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if covered != r {
+		return nil, nil
+	}
+	return groups, nil
+}
+
+// outputs returns the output files recorded for every task of a phase, in
+// task order
+func (j *journal) outputs(id, phase string) ([]string, error) {
+	rows, err := j.db.Query("select outputs from tasks where job_id = ? and phase = ? order by n", id, phase)
+	if err != nil {
+		slog.Error("reading task outputs from journal", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var outputs string
+		if err := rows.Scan(&outputs); err != nil {
+			return nil, err
+		}
+		paths = append(paths, strings.Split(outputs, "\n")...)
+	}
+	return paths, rows.Err()
+}
+
+func (j *journal) recordTask(id, phase string, n int, worker string, outputs []string, counters map[string]int64) error {
+	encoded, err := json.Marshal(counters)
+	if err != nil {
+		return err
+	}
+	_, err = j.db.Exec("insert or replace into tasks (job_id, phase, n, worker, outputs, counters) values (?, ?, ?, ?, ?, ?)",
+		id, phase, n, worker, strings.Join(outputs, "\n"), string(encoded))
+	if err != nil {
+		slog.Error("recording task in journal", "phase", phase, "task", n, "err", err)
+	}
+	return err
+}
+
+// counters returns the user counters of every task the job finished,
+// summed, whether or not the tasks' outputs are still in place
+func (j *journal) counters(id string) (map[string]int64, error) {
+	rows, err := j.db.Query("select counters from tasks where job_id = ?", id)
+	if err != nil {
+		slog.Error("reading task counters from journal", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	total := NewCounters()
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var counters map[string]int64
+		if err := json.Unmarshal([]byte(encoded), &counters); err != nil {
+			slog.Error("decoding task counters from journal", "err", err)
+			return nil, err
+		}
+		total.Merge(counters)
+	}
+	return total.Snapshot(), rows.Err()
+}
+
+// forgetJob removes everything the journal holds about a job, so it can be
+// run again from the start
+func (j *journal) forgetJob(id string) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"tasks", "reduces", "bounds", "splits"} {
+		if _, err := tx.Exec("delete from "+table+" where job_id = ?", id); err != nil {
+			slog.Error("removing job from journal", "err", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec("delete from jobs where id = ?", id); err != nil {
+		slog.Error("removing job from journal", "err", err)
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// a task an earlier run of the job finished
+type finishedTask struct {
+	Worker   string           // holds the task's output
+	Outputs  []string         // files the task wrote
+	Counters map[string]int64 // user counters the task reported
+}
+
+// finishedTasks returns each task in a phase that finished and whose
+// output files are all still in place
+func (j *journal) finishedTasks(id, phase string) (map[int]finishedTask, error) {
+	rows, err := j.db.Query("select n, worker, outputs, counters from tasks where job_id = ? and phase = ?", id, phase)
+	if err != nil {
+		slog.Error("reading tasks from journal", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	done := make(map[int]finishedTask)
+	for rows.Next() {
+		var n int
+		var worker, outputs, counters string
+		if err := rows.Scan(&n, &worker, &outputs, &counters); err != nil {
+			return nil, err
+		}
+		paths := strings.Split(outputs, "\n")
+		if !outputsIntact(paths) {
+			continue
+		}
+		task := finishedTask{Worker: worker, Outputs: paths}
+		if err := json.Unmarshal([]byte(counters), &task.Counters); err != nil {
+			slog.Error("decoding task counters from journal", "phase", phase, "task", n, "err", err)
+			return nil, err
+		}
+		done[n] = task
+	}
+	return done, rows.Err()
+}
+
+// outputsIntact checks that each file still matches the checksum recorded
+// when it was written
+func outputsIntact(paths []string) bool {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		want, _, err := readChecksum(path)
+		if err != nil {
+			return false
+		}
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+		got, _, err := fileChecksum(path)
+		if err != nil || got != want {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package mapreduce
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournalKeepsJobSettings(t *testing.T) {
+	j, err := openJournal(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	spec := &JobSpec{
+		Sources: []string{"a.db"}, KeyOrder: "numeric", CoalesceBytes: 1 << 20,
+		Files: []string{"stop.txt"}, Conf: JobConf{"n": "3"}, Mapper: "cat",
+	}
+	job := &Job{ID: "job", Sources: spec.Sources, M: 2, R: 1, TempDir: t.TempDir(), Spec: spec}
+	if err := j.createJob(job); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := j.loadJob("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Spec == nil || !loaded.Spec.same(spec) {
+		t.Fatalf("journal gave back settings %+v, want %+v", loaded.Spec, spec)
+	}
+	if err := loaded.Spec.apply(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findClient[*Streaming](loaded.Client); !ok {
+		t.Errorf("resumed job runs %T, want the streaming mapper", loaded.Client)
+	}
+	if loaded.Conf.String("n", "") != "3" || loaded.CoalesceBytes != 1<<20 || len(loaded.Files) != 1 {
+		t.Errorf("resumed job settings %+v", loaded)
+	}
+}
+
+func TestJournalFromBeforeJobSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`create table jobs (id text primary key, source text not null, m integer not null,
+		r integer not null, tempdir text not null, state text not null)`)
+	if err == nil {
+		_, err = db.Exec("insert into jobs values ('old', 'austen.db', 4, 2, '/tmp/old', 'running')")
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	job, err := j.loadJob("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Spec != nil || job.M != 4 {
+		t.Errorf("old job loaded as %+v", job)
+	}
+}
+
+func TestRerunWithChangedSettings(t *testing.T) {
+	dir := t.TempDir()
+	j, err := openJournal(filepath.Join(dir, "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	address, data, stop := (&FakeCluster{}).driver()
+	defer stop()
+
+	source := writeSource(t, dir, lines(10, "the cat"))
+	run := func(spec *JobSpec) error {
+		job := &Job{ID: "job", Sources: spec.Sources, M: 2, R: 1, TempDir: filepath.Join(dir, "job"), Address: address,
+			Status: newJobStatus("job", 2, 1), Journal: j}
+		if err := spec.apply(job); err != nil {
+			return err
+		}
+		return runStage(job, data, nil)
+	}
+	spec := &JobSpec{Sources: []string{source}, KeyOrder: "bytes"}
+	if err := run(spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(&JobSpec{Sources: []string{source}, KeyOrder: "bytes"}); err != nil {
+		t.Errorf("rerun with the same settings: %v", err)
+	}
+	err = run(&JobSpec{Sources: []string{source}, KeyOrder: "bytes", Mapper: "cat"})
+	if err == nil || !strings.Contains(err.Error(), "different settings") {
+		t.Errorf("rerun with a mapper added: got %v, want a settings error", err)
+	}
+}
@@ -0,0 +1,288 @@
+package mapreduce
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyComparator orders keys for the reduce sort. It returns a negative
+// number when a sorts before b and a positive one when it sorts after. Map
+// output is partitioned on the bytes of each key, so it should return 0
+// only for identical keys; keys meant to be reduced together should be
+// written the same way by Map.
+type KeyComparator func(a, b string) int
+
+var (
+	// NumericKeys sorts keys that parse as numbers by value, ahead of keys
+	// that don't, which sort byte-wise. Different spellings of one number
+	// ("1", "1.0") stay separate keys.
+	NumericKeys KeyComparator = compareNumeric
+
+	// CaseInsensitiveKeys sorts keys ignoring case. Keys that differ only
+	// in case sort next to each other but are still reduced separately.
+	CaseInsensitiveKeys KeyComparator = compareFolded
+)
+
+func compareNumeric(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB != nil:
+		return -1
+	case errA != nil && errB == nil:
+		return 1
+	case errA == nil && x < y:
+		return -1
+	case errA == nil && x > y:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareFolded(a, b string) int {
+	if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// Reverse returns the opposite order. Reversing a nil comparator reverses
+// the default byte-wise order.
+func (c KeyComparator) Reverse() KeyComparator {
+	return func(a, b string) int {
+		if c == nil {
+			return strings.Compare(b, a)
+		}
+		return c(b, a)
+	}
+}
+
+// same reports whether a and b belong to the same Reduce call
+func (c KeyComparator) same(a, b string) bool {
+	if c == nil {
+		return a == b
+	}
+	return c(a, b) == 0
+}
+
+// parseKeyOrder turns a -key-order flag into a comparator: bytes, numeric
+// or nocase, optionally prefixed with reverse-. bytes gives nil, leaving
+// the sort to SQLite.
+func parseKeyOrder(name string) (KeyComparator, error) {
+	base, reverse := strings.CutPrefix(name, "reverse-")
+	var compare KeyComparator
+	switch base {
+	case "bytes":
+	case "numeric":
+		compare = NumericKeys
+	case "nocase":
+		compare = CaseInsensitiveKeys
+	default:
+		return nil, fmt.Errorf("unknown key order %q", name)
+	}
+	if reverse {
+		compare = compare.Reverse()
+	}
+	return compare, nil
+}
+
+// OrderedInterface is implemented by Interface implementations that want
+// their keys in an order other than byte-wise. CompareKeys sorts each map
+// output partition, sorts and groups the reduce input, and orders the
+// reduce output.
+type OrderedInterface interface {
+	Interface
+	CompareKeys(a, b string) int
+}
+
+// SecondarySortInterface is implemented by Interface implementations whose
+// map output keys are composite. SplitKey breaks a key into the part pairs
+// are partitioned and grouped on and the part that orders values within a
+// group; Reduce is called once per grouping key, and gets that key and the
+// values ordered by CompareSortKeys.
+type SecondarySortInterface interface {
+	Interface
+	SplitKey(key string) (group, sort string)
+	CompareSortKeys(a, b string) int
+}
+
+// SplitAt returns a SplitKey function for keys written as group + sep +
+// sort. A key without sep is all grouping key.
+func SplitAt(sep string) func(key string) (group, sort string) {
+	return func(key string) (string, string) {
+		group, sort, _ := strings.Cut(key, sep)
+		return group, sort
+	}
+}
+
+// keyOrder is how a job's pairs are sorted and grouped
+type keyOrder struct {
+	compare     KeyComparator                         // grouping keys; nil is byte-wise
+	split       func(key string) (group, sort string) // nil when keys aren't composite
+	compareSort KeyComparator                         // sort keys; nil is byte-wise
+}
+
+// keyOrderOf returns the order client asked for
+func keyOrderOf(client Interface) keyOrder {
+	if o, ok := client.(orderedClient); ok {
+		return o.order
+	}
+	var order keyOrder
+	if oi, ok := client.(OrderedInterface); ok {
+		order.compare = oi.CompareKeys
+	}
+	if si, ok := client.(SecondarySortInterface); ok {
+		order.split = si.SplitKey
+		order.compareSort = si.CompareSortKeys
+	}
+	return order
+}
+
+// custom reports whether pairs need sorting here rather than by SQLite
+func (o keyOrder) custom() bool {
+	return o.compare != nil || o.split != nil
+}
+
+// group returns the part of key that pairs are partitioned and grouped on
+func (o keyOrder) group(key string) string {
+	if o.split == nil {
+		return key
+	}
+	group, _ := o.split(key)
+	return group
+}
+
+func (o keyOrder) less(a, b string) bool {
+	compare, compareSort := o.compare, o.compareSort
+	if compare == nil {
+		compare = strings.Compare
+	}
+	if compareSort == nil {
+		compareSort = strings.Compare
+	}
+	if o.split == nil {
+		return compare(a, b) < 0
+	}
+	groupA, sortA := o.split(a)
+	groupB, sortB := o.split(b)
+	if c := compare(groupA, groupB); c != 0 {
+		return c < 0
+	}
+	return compareSort(sortA, sortB) < 0
+}
+
+// WithKeyOrder returns client with its keys ordered by compare
+func WithKeyOrder(client Interface, compare KeyComparator) Interface {
+	o := asOrdered(client)
+	o.order.compare = compare
+	return o
+}
+
+// WithSecondarySort returns client with its keys split into grouping and
+// sort keys by split, and values ordered by compare on the sort keys; nil
+// orders them byte-wise
+func WithSecondarySort(client Interface, split func(key string) (group, sort string), compare KeyComparator) Interface {
+	o := asOrdered(client)
+	o.order.split = split
+	o.order.compareSort = compare
+	return o
+}
+
+type orderedClient struct {
+	Interface
+	order keyOrder
+}
+
+func asOrdered(client Interface) orderedClient {
+	if o, ok := client.(orderedClient); ok {
+		return o
+	}
+	return orderedClient{Interface: client, order: keyOrderOf(client)}
+}
+
+func (o orderedClient) Unwrap() Interface {
+	return o.Interface
+}
+
+// sortPairs sorts pairs by key, keeping pairs that sort equal in the order
+// they came in
+func sortPairs(pairs []Pair, order keyOrder) {
+	sort.SliceStable(pairs, func(a, b int) bool {
+		return order.less(pairs[a].Key, pairs[b].Key)
+	})
+}
+
+// sortedInput reads a merged reduce input in key order. For byte-wise keys
+// SQLite sorts and rows are streamed; otherwise the whole partition is read
+// and sorted in memory.
+type sortedInput struct {
+	rows  *sql.Rows
+	pairs []Pair
+	next  int
+	pair  Pair
+	err   error
+}
+
+func readReduceInput(db *sql.DB, order keyOrder) (*sortedInput, error) {
+	rows, err := db.Query("select key, value from pairs order by key, value")
+	if err != nil {
+		return nil, err
+	}
+	if !order.custom() {
+		return &sortedInput{rows: rows}, nil
+	}
+	defer rows.Close()
+	var pairs []Pair
+	for rows.Next() {
+		var p Pair
+		if err := rows.Scan(&p.Key, &p.Value); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortPairs(pairs, order)
+	return &sortedInput{pairs: pairs}, nil
+}
+
+func (in *sortedInput) Next() bool {
+	if in.rows == nil {
+		if in.next >= len(in.pairs) {
+			return false
+		}
+		in.pair = in.pairs[in.next]
+		in.next++
+		return true
+	}
+	if !in.rows.Next() {
+		return false
+	}
+	if err := in.rows.Scan(&in.pair.Key, &in.pair.Value); err != nil {
+		in.err = err
+		return false
+	}
+	return true
+}
+
+func (in *sortedInput) Pair() Pair {
+	return in.pair
+}
+
+func (in *sortedInput) Err() error {
+	if in.err != nil || in.rows == nil {
+		return in.err
+	}
+	return in.rows.Err()
+}
+
+func (in *sortedInput) Close() error {
+	if in.rows == nil {
+		return nil
+	}
+	return in.rows.Close()
+}
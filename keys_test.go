@@ -0,0 +1,93 @@
+package mapreduce
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// partitions runs stage on its own over input and returns each reduce
+// output in the order the job wrote it, which FakeCluster.Run would sort
+func partitions(t *testing.T, stage *Stage, input []Pair) [][]Pair {
+	t.Helper()
+	dir := t.TempDir()
+	stage.Name = "job"
+	stage.Sources = []string{writeSource(t, dir, input)}
+	d := &DAG{ID: "job", Dir: filepath.Join(dir, "job"), Stages: []*Stage{stage}}
+	var stop func()
+	d.Address, d.Data, stop = (&FakeCluster{}).driver()
+	defer stop()
+	outputs, err := d.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts [][]Pair
+	for _, path := range outputs["job"] {
+		pairs, err := readPairs(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, pairs)
+	}
+	return parts
+}
+
+func keysOf(pairs []Pair) []string {
+	var keys []string
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+	return keys
+}
+
+// countValues counts the records with each value
+type countValues struct{}
+
+func (countValues) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	output <- Pair{Key: value, Value: key}
+	return nil
+}
+
+func (countValues) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	n := 0
+	for range values {
+		n++
+	}
+	output <- Pair{Key: key, Value: strconv.Itoa(n)}
+	return nil
+}
+
+func TestKeyOrders(t *testing.T) {
+	var input []Pair
+	for i, value := range []string{"10", "9", "100", "x", "9", "Foo", "foo", "FOO", "2.5", "bar"} {
+		input = append(input, Pair{Key: strconv.Itoa(i), Value: value})
+	}
+	for order, want := range map[string][]string{
+		"bytes":           {"10", "100", "2.5", "9", "FOO", "Foo", "bar", "foo", "x"},
+		"reverse-bytes":   {"x", "foo", "bar", "Foo", "FOO", "9", "2.5", "100", "10"},
+		"numeric":         {"2.5", "9", "10", "100", "FOO", "Foo", "bar", "foo", "x"},
+		"reverse-numeric": {"x", "foo", "bar", "Foo", "FOO", "100", "10", "9", "2.5"},
+		"nocase":          {"10", "100", "2.5", "9", "bar", "FOO", "Foo", "foo", "x"},
+	} {
+		compare, err := parseKeyOrder(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := partitions(t, &Stage{Client: WithKeyOrder(countValues{}, compare), M: 3, R: 1}, input)
+		if got := keysOf(parts[0]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: keys in order %v, want %v", order, got, want)
+		}
+		// keys equal under the order are still reduced apart
+		for _, pair := range parts[0] {
+			if pair.Key == "9" && pair.Value != "2" || pair.Key == "foo" && pair.Value != "1" {
+				t.Errorf("%s: %s counted %s times", order, pair.Key, pair.Value)
+			}
+		}
+	}
+	if _, err := parseKeyOrder("random"); err == nil {
+		t.Error("unknown key order accepted")
+	}
+}
@@ -0,0 +1,56 @@
+package mapreduce
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// newJobID names a run of the driver so its lines can be picked out of
+// many workers' logs
+func newJobID() string {
+	return fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), os.Getpid())
+}
+
+// setupLogging installs the default logger, tagged with the job ID. format
+// is "text" or "json"; level is one of debug, info, warn or error. Lines
+// still written with the log package go through the same handler.
+func setupLogging(format, level, jobID string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(handler).With("job", jobID))
+	return nil
+}
+
+// fatal logs an error and exits, for the driver's unrecoverable failures
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func (task *MapTask) logger() *slog.Logger {
+	return slog.Default().With("phase", "map", "task", task.N, "attempt", task.Attempt, "worker", task.Worker)
+}
+
+func (task *ReduceTask) logger() *slog.Logger {
+	return slog.Default().With("phase", "reduce", "task", task.N, "attempt", task.Attempt, "worker", task.Worker)
+}
+
+func (task *CombineTask) logger() *slog.Logger {
+	return slog.Default().With("phase", "combine", "task", task.N, "salt", task.Salt, "attempt", task.Attempt, "worker", task.Worker)
+}
@@ -0,0 +1,62 @@
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoggingFields(t *testing.T) {
+	defer func(logger *slog.Logger, stderr *os.File) {
+		slog.SetDefault(logger)
+		os.Stderr = stderr
+	}(slog.Default(), os.Stderr)
+	out, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	os.Stderr = out
+
+	if err := setupLogging("json", "info", "job-1"); err != nil {
+		t.Fatal(err)
+	}
+	(&ReduceTask{N: 3, Attempt: 2, Worker: "w1"}).logger().Info("reducing", "key", "cat")
+	slog.Debug("below the level")
+	log.Print("from the log package")
+
+	contents, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(contents), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), contents)
+	}
+	var line map[string]any
+	if err := json.Unmarshal(lines[0], &line); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{"msg": "reducing", "job": "job-1", "phase": "reduce", "task": 3.0, "attempt": 2.0, "worker": "w1", "key": "cat"} {
+		if line[key] != want {
+			t.Errorf("%s is %v, want %v", key, line[key], want)
+		}
+	}
+	if err := json.Unmarshal(lines[1], &line); err != nil || line["msg"] != "from the log package" || line["job"] != "job-1" {
+		t.Errorf("log package line %s", lines[1])
+	}
+}
+
+func TestLoggingOptions(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	if err := setupLogging("xml", "info", "job"); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if err := setupLogging("text", "loud", "job"); err == nil {
+		t.Error("unknown level accepted")
+	}
+}
@@ -0,0 +1,113 @@
+package mapreduce
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// name the driver serves a job's lookup database under, and the copy each
+// worker downloads it to
+const (
+	lookupFile      = "lookup.db"
+	lookupInputFile = "lookup_input.db"
+)
+
+// Lookup is a small pairs database held in memory so Map can enrich
+// records from it without the table going through the shuffle. Map tasks
+// get it from JobContext.Lookup.
+type Lookup struct {
+	values map[string][]string
+}
+
+// Get returns the first value stored under key
+func (l *Lookup) Get(key string) (string, bool) {
+	if l == nil || len(l.values[key]) == 0 {
+		return "", false
+	}
+	return l.values[key][0], true
+}
+
+// All returns every value stored under key, in table order
+func (l *Lookup) All(key string) []string {
+	if l == nil {
+		return nil
+	}
+	return l.values[key]
+}
+
+func (l *Lookup) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.values)
+}
+
+func loadLookup(path string) (*Lookup, error) {
+	db, err := openDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("select key, value from pairs")
+	if err != nil {
+		slog.Error("select query from lookup database", "file", path, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	l := &Lookup{values: make(map[string][]string)}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		l.values[key] = append(l.values[key], value)
+	}
+	return l, rows.Err()
+}
+
+// lookups a worker has already downloaded and loaded, by the local path of
+// the download, so each job directory fetches its table once
+var lookupCache = struct {
+	sync.Mutex
+	tables map[string]*Lookup
+}{tables: make(map[string]*Lookup)}
+
+// fetchLookup returns the lookup table served by host, downloading it
+// into dir unless this worker already has it
+func fetchLookup(logger *slog.Logger, trace *taskTrace, host, dir string) (*Lookup, error) {
+	path := filepath.Join(dir, lookupInputFile)
+	lookupCache.Lock()
+	defer lookupCache.Unlock()
+	if l, ok := lookupCache.tables[path]; ok {
+		if _, err := os.Stat(path); err == nil {
+			return l, nil
+		}
+	}
+
+	url := makeURL(host, lookupFile)
+	end := trace.begin("lookup", "url", url)
+	defer end()
+	if err := download(logger, url, path); err != nil {
+		logger.Error("downloading lookup table", "url", url, "err", err)
+		return nil, err
+	}
+	l, err := loadLookup(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("loaded lookup table", "file", path, "keys", l.Len())
+	lookupCache.tables[path] = l
+	return l, nil
+}
+
+// prepareLookup copies the job's lookup database into its temp dir, where
+// the data server hands it to the map tasks
+func (job *Job) prepareLookup() error {
+	dest := filepath.Join(job.TempDir, lookupFile)
+	if err := copyFile(job.Lookup, dest); err != nil {
+		return err
+	}
+	return writeChecksum(dest)
+}
@@ -0,0 +1,151 @@
+package mapreduce
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a small hand-rolled set of metrics, written out in the Prometheus text
+// exposition format at /metrics
+
+// counter is a monotonically increasing value split by label values
+type counter struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // keyed by rendered label set
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func renderLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var parts []string
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (c *counter) add(v float64, labelValues ...string) {
+	key := renderLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, key, c.values[key])
+	}
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64 // upper bounds, ascending
+	counts  []uint64  // one per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets ...float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", h.name, h.sum, h.name, h.count)
+}
+
+var (
+	tasksMetric = newCounter("mapreduce_tasks_total",
+		"Tasks finished, by phase and result.", "phase", "result")
+	recordsInMetric = newCounter("mapreduce_records_in_total",
+		"Pairs read by finished tasks, by phase.", "phase")
+	recordsOutMetric = newCounter("mapreduce_records_out_total",
+		"Pairs written by finished tasks, by phase.", "phase")
+	servedBytesMetric = newCounter("mapreduce_data_served_bytes_total",
+		"File bytes sent by the /data/ handler, leaving out error responses.")
+	insertedRowsMetric = newCounter("mapreduce_sqlite_inserted_rows_total",
+		"Rows inserted into SQLite output databases.")
+	insertSecondsMetric = newCounter("mapreduce_sqlite_insert_seconds_total",
+		"Time spent inserting rows into SQLite output databases.")
+	downloadMetric = newHistogram("mapreduce_download_duration_seconds",
+		"Time taken by download to fetch one file, including retries.",
+		0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10)
+)
+
+func recordTaskMetrics(phase string, stats TaskStats, err error) {
+	result := "completed"
+	if err != nil {
+		result = "failed"
+	}
+	tasksMetric.add(1, phase, result)
+	recordsInMetric.add(float64(stats.RecordsIn), phase)
+	recordsOutMetric.add(float64(stats.RecordsOut), phase)
+}
+
+func recordInserts(rows int, elapsed time.Duration) {
+	insertedRowsMetric.add(float64(rows))
+	insertSecondsMetric.add(elapsed.Seconds())
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, c := range []*counter{tasksMetric, recordsInMetric, recordsOutMetric, servedBytesMetric, insertedRowsMetric, insertSecondsMetric} {
+		c.write(w)
+	}
+	downloadMetric.write(w)
+	fmt.Fprintf(w, "# HELP go_goroutines Number of goroutines that currently exist.\n# TYPE go_goroutines gauge\ngo_goroutines %d\n", runtime.NumGoroutine())
+}
+
+// a counter with no observations yet still shows up with a zero value
+func init() {
+	for _, c := range []*counter{servedBytesMetric, insertedRowsMetric, insertSecondsMetric} {
+		c.add(0)
+	}
+}
@@ -0,0 +1,104 @@
+package mapreduce
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCounterExposition(t *testing.T) {
+	c := newCounter("test_total", "Things.", "phase", "result")
+	c.add(2, "map", "completed")
+	c.add(1, "map", "completed")
+	c.add(1, "reduce", `odd "name"`)
+	var b strings.Builder
+	c.write(&b)
+	want := `# HELP test_total Things.
+# TYPE test_total counter
+test_total{phase="map",result="completed"} 3
+test_total{phase="reduce",result="odd \"name\""} 1
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	h := newHistogram("test_seconds", "Time.", 0.1, 1)
+	for _, v := range []float64{0.05, 0.5, 0.7, 5} {
+		h.observe(v)
+	}
+	var b strings.Builder
+	h.write(&b)
+	want := `# HELP test_seconds Time.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 6.25
+test_seconds_count 4
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestMetricsAfterJob(t *testing.T) {
+	if _, err := (&FakeCluster{M: 2, R: 2}).Run(&Client{}, lines(10, "the cat")); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	serveMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE mapreduce_tasks_total counter",
+		`mapreduce_tasks_total{phase="map",result="completed"}`,
+		`mapreduce_tasks_total{phase="reduce",result="completed"}`,
+		"mapreduce_download_duration_seconds_count",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, "\n"+line) && !strings.HasPrefix(body, line) {
+			t.Errorf("metrics have no %q", line)
+		}
+	}
+}
+
+func TestServedBytesLeaveOutErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, mapOutputFile(0, 0))
+	if err := os.WriteFile(path, []byte("some pairs"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeChecksum(path); err != nil {
+		t.Fatal(err)
+	}
+	h := newDataHandler(dir, 1, 2, "secret")
+	servedBytes := func() float64 {
+		servedBytesMetric.mu.Lock()
+		defer servedBytesMetric.mu.Unlock()
+		return servedBytesMetric.values[""]
+	}
+
+	get := func(file, token string) {
+		req := httptest.NewRequest("GET", file, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	before := servedBytes()
+	get("/map_0_output_0.db", "")       // 401
+	get("/map_0_output_1.db", "secret") // 404, never written
+	get("/nothing.db", "secret")        // 404, not a job file
+	if got := servedBytes(); got != before {
+		t.Errorf("error responses added %g served bytes", got-before)
+	}
+
+	get("/map_0_output_0.db", "secret")
+	if got := servedBytes() - before; got != float64(len("some pairs")) {
+		t.Errorf("served %g bytes, want %d", got, len("some pairs"))
+	}
+}
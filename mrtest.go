@@ -0,0 +1,391 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeCluster runs a job entirely inside this process so Interface
+// implementations, here or in a package importing this one, can be
+// checked from their tests against the real MapTask and ReduceTask code.
+// Each simulated worker gets its own directory and a /data/ server on an
+// httptest listener; map tasks are spread across workers round-robin and
+// reduce tasks fetch map output from whichever worker ran the map task.
+//
+//	fc := &mapreduce.FakeCluster{M: 4, R: 2, Workers: 2}
+//	out, err := fc.Run(&mapreduce.Client{}, []mapreduce.Pair{{Key: "line 1", Value: "the cat"}})
+type FakeCluster struct {
+	M, R        int       // number of map and reduce tasks
+	Workers     int       // simulated workers; 0 means 1
+	MaxAttempts int       // tries per task before the job fails; 0 means 1
+	Failures    []Failure // faults to inject while the job runs
+	TotalOrder  bool      // partition by sampled key ranges, as Job.TotalOrder
+	Conf        JobConf   // job parameters, as Job.Conf
+	Lookup      []Pair    // lookup table for the map tasks, as Job.Lookup
+	Files       []string  // side files shipped to every worker, as Job.Files
+
+	// filled in by Run for inspection afterwards
+	Status *jobStatus
+}
+
+type FailureKind int
+
+const (
+	FailFetch    FailureKind = iota // the data server answers with a 500
+	CorruptFetch                    // the data server flips a byte of the file
+	FailTask                        // Map or Reduce returns an error
+)
+
+// Failure injects a fault into one task. Fetch faults apply to the files
+// the task downloads: its split for a map task, every map output for its
+// partition for a reduce task.
+type Failure struct {
+	Kind  FailureKind
+	Phase string // "map" or "reduce"
+	Task  int
+	Times int // how many times to inject it; 0 means once
+}
+
+var errInjected = errors.New("injected failure")
+
+// injector hands out the configured failures, each until it is used up
+type injector struct {
+	mu       sync.Mutex
+	failures []Failure
+	used     []int
+}
+
+func (in *injector) take(kind FailureKind, phase string, task int) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for i, f := range in.failures {
+		times := f.Times
+		if times == 0 {
+			times = 1
+		}
+		if f.Kind == kind && f.Phase == phase && f.Task == task && in.used[i] < times {
+			in.used[i]++
+			return true
+		}
+	}
+	return false
+}
+
+// fetchTarget works out which task a data request is feeding
+func fetchTarget(name string) (string, int, bool) {
+	if match := mapSourcePattern.FindStringSubmatch(name); match != nil {
+		var n int
+		fmt.Sscanf(match[1], "%d", &n)
+		return "map", n, true
+	}
+	if match := mapOutputPattern.FindStringSubmatch(name); match != nil {
+		var r int
+		fmt.Sscanf(match[2], "%d", &r)
+		return "reduce", r, true
+	}
+	return "", 0, false
+}
+
+type corruptingWriter struct {
+	http.ResponseWriter
+	flipped bool
+}
+
+func (c *corruptingWriter) Write(p []byte) (int, error) {
+	if !c.flipped && len(p) > 0 {
+		c.flipped = true
+		p = append([]byte(nil), p...)
+		p[len(p)/2] ^= 0xff
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+func (in *injector) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		phase, task, ok := fetchTarget(strings.TrimPrefix(r.URL.Path, "/data/"))
+		switch {
+		case ok && in.take(FailFetch, phase, task):
+			http.Error(w, errInjected.Error(), http.StatusInternalServerError)
+		case ok && in.take(CorruptFetch, phase, task):
+			h.ServeHTTP(&corruptingWriter{ResponseWriter: w}, r)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// failingClient wraps user code so injected task failures surface as an
+// error from Map or Reduce
+type failingClient struct {
+	Interface
+	fail bool
+}
+
+func (f failingClient) Unwrap() Interface {
+	return f.Interface
+}
+
+func (f failingClient) Map(key, value string, output chan<- Pair) error {
+	if f.fail {
+		close(output)
+		return errInjected
+	}
+	return f.Interface.Map(key, value, output)
+}
+
+func (f failingClient) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	if f.fail {
+		close(output)
+		return errInjected
+	}
+	return f.Interface.Reduce(key, values, output)
+}
+
+type fakeWorker struct {
+	dir     string
+	address string
+	server  *httptest.Server
+}
+
+// Run builds a source database from input, runs the job and returns every
+// reduce output pair, sorted by key in the client's key order and then by
+// value
+func (fc *FakeCluster) Run(client Interface, input []Pair) ([]Pair, error) {
+	if fc.M < 1 || fc.R < 1 {
+		return nil, fmt.Errorf("fake cluster needs M and R of at least 1, got %d and %d", fc.M, fc.R)
+	}
+	workers, attempts := fc.Workers, fc.MaxAttempts
+	if workers < 1 {
+		workers = 1
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	in := &injector{failures: fc.Failures, used: make([]int, len(fc.Failures))}
+
+	root, err := os.MkdirTemp("", "mrtest.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+
+	var nodes []*fakeWorker
+	defer func() {
+		for _, node := range nodes {
+			node.server.Close()
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("worker_%d", i))
+		if err := os.Mkdir(dir, 0700); err != nil {
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/data/", in.wrap(http.StripPrefix("/data", newDataHandler(dir, fc.M, fc.R, dataToken))))
+		server := httptest.NewServer(mux)
+		nodes = append(nodes, &fakeWorker{dir: dir, address: server.Listener.Addr().(*net.TCPAddr).String(), server: server})
+	}
+
+	// the first worker doubles as the driver that holds the splits
+	source := filepath.Join(root, "source.db")
+	if err := writePairs(source, input); err != nil {
+		return nil, err
+	}
+	splits := createPaths(fc.M, mapSource, nodes[0].dir)
+	if err := splitDatabase(source, splits); err != nil {
+		return nil, err
+	}
+	files, err := (&Job{Files: fc.Files, TempDir: nodes[0].dir}).prepareFiles()
+	if err != nil {
+		return nil, err
+	}
+	lookupHost := ""
+	if fc.Lookup != nil {
+		lookup := filepath.Join(nodes[0].dir, lookupFile)
+		if err := writePairs(lookup, fc.Lookup); err != nil {
+			return nil, err
+		}
+		if err := writeChecksum(lookup); err != nil {
+			return nil, err
+		}
+		lookupHost = nodes[0].address
+	}
+	var bounds []string
+	if fc.TotalOrder && fc.R > 1 {
+		ctx, err := fetchSideFiles(slog.Default(), new(taskTrace), nodes[0].address, nodes[0].dir, files)
+		if err != nil {
+			return nil, err
+		}
+		ctx.phase, ctx.conf = "sample", fc.Conf
+		if bounds, err = sampleBounds(splits, client, ctx, fc.R); err != nil {
+			return nil, err
+		}
+	}
+
+	fc.Status = newJobStatus(source, fc.M, fc.R)
+	clientFor := func(phase string, n int) Interface {
+		if in.take(FailTask, phase, n) {
+			return failingClient{Interface: client, fail: true}
+		}
+		return client
+	}
+
+	mapHosts := make([]string, fc.M)
+	for i := 0; i < fc.M; i++ {
+		node := nodes[i%workers]
+		var err error
+		for try := 0; try < attempts; try++ {
+			task := &MapTask{M: fc.M, R: fc.R, N: i, SourceHost: nodes[0].address, Worker: node.address, Bounds: bounds, LookupHost: lookupHost, FilesHost: nodes[0].address, Files: files, Conf: fc.Conf}
+			task.Attempt = fc.Status.start("map", i, node.address)
+			err = task.Process(node.dir, clientFor("map", i))
+			fc.Status.finish("map", i, task.Stats, err)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("map task %d: %w", i, err)
+		}
+		mapHosts[i] = node.address
+	}
+
+	var outputs []string
+	for i := 0; i < fc.R; i++ {
+		node := nodes[i%workers]
+		var err error
+		for try := 0; try < attempts; try++ {
+			task := &ReduceTask{M: fc.M, R: fc.R, N: i, SourceHosts: mapHosts, Worker: node.address, FilesHost: nodes[0].address, Files: files, Conf: fc.Conf}
+			task.Attempt = fc.Status.start("reduce", i, node.address)
+			err = task.Process(node.dir, clientFor("reduce", i))
+			fc.Status.finish("reduce", i, task.Stats, err)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reduce task %d: %w", i, err)
+		}
+		outputs = append(outputs, filepath.Join(node.dir, reduceOutputFile(i)))
+	}
+
+	result, err := readOutputs(outputs)
+	if err != nil {
+		return nil, err
+	}
+	compare := keyOrderOf(client).compare
+	if compare == nil {
+		compare = strings.Compare
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if c := compare(result[a].Key, result[b].Key); c != 0 {
+			return c < 0
+		}
+		return result[a].Value < result[b].Value
+	})
+	return result, nil
+}
+
+// driver starts a data server for jobs driven from this process, such as
+// the stages of a DAG, that injects the configured fetch faults. It returns
+// the server's address, the switch to point at each job's data handler and
+// a function that stops the server.
+func (fc *FakeCluster) driver() (string, *handlerSwitch, func()) {
+	in := &injector{failures: fc.Failures, used: make([]int, len(fc.Failures))}
+	data := new(handlerSwitch)
+	mux := http.NewServeMux()
+	mux.Handle("/data/", in.wrap(data))
+	server := httptest.NewServer(mux)
+	return server.Listener.Addr().(*net.TCPAddr).String(), data, server.Close
+}
+
+// RunDAG runs d from this process, filling in its Address and Data, and
+// returns the reduce output pairs of every stage in output order. d.Dir has
+// to be set. Only fetch faults apply, to the tasks of every stage.
+func (fc *FakeCluster) RunDAG(d *DAG) (map[string][]Pair, error) {
+	var stop func()
+	d.Address, d.Data, stop = fc.driver()
+	defer stop()
+	outputs, err := d.Run()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]Pair)
+	for name, paths := range outputs {
+		if result[name], err = readOutputs(paths); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// RunIterative runs it from this process, as RunDAG does a DAG, and returns
+// the final reduce output pairs in output order and the number of
+// iterations run
+func (fc *FakeCluster) RunIterative(it *Iterative) ([]Pair, int, error) {
+	var stop func()
+	it.Address, it.Data, stop = fc.driver()
+	defer stop()
+	outputs, n, err := it.Run()
+	if err != nil {
+		return nil, n, err
+	}
+	pairs, err := readOutputs(outputs)
+	return pairs, n, err
+}
+
+// readOutputs reads every pair of a job's output files, file by file
+func readOutputs(paths []string) ([]Pair, error) {
+	var pairs []Pair
+	for _, path := range paths {
+		more, err := readPairs(path)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, more...)
+	}
+	return pairs, nil
+}
+
+// writePairs creates a pairs database at path holding the given pairs
+func writePairs(path string, pairs []Pair) error {
+	db, err := createDatabase(path)
+	if err != nil {
+		return err
+	}
+	if err := InsertPair(0, 0, db, pairs); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// readPairs returns every pair in a pairs database
+func readPairs(path string) ([]Pair, error) {
+	db, err := openDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("select key, value from pairs")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pairs []Pair
+	for rows.Next() {
+		var p Pair
+		if err := rows.Scan(&p.Key, &p.Value); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, rows.Err()
+}
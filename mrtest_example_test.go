@@ -0,0 +1,27 @@
+package mapreduce_test
+
+import (
+	"fmt"
+
+	"mapreduce"
+)
+
+func ExampleFakeCluster() {
+	fc := &mapreduce.FakeCluster{M: 2, R: 2, Workers: 2}
+	out, err := fc.Run(&mapreduce.Client{}, []mapreduce.Pair{
+		{Key: "line 1", Value: "The cat sat"},
+		{Key: "line 2", Value: "the mat"},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, pair := range out {
+		fmt.Println(pair.Key, pair.Value)
+	}
+	// Output:
+	// cat 1
+	// mat 1
+	// sat 1
+	// the 2
+}
@@ -0,0 +1,155 @@
+package mapreduce
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// names a Pair's Output may take; starting with a letter keeps named map
+// outputs apart from map_N_output_R.db partitions
+var outputNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+func mapOnlyOutputFile(m int) string {
+	return fmt.Sprintf("map_%d_output.db", m)
+}
+
+func mapNamedOutputFile(m int, name string) string {
+	return fmt.Sprintf("map_%d_output_%s.db", m, name)
+}
+
+func reduceNamedOutputFile(r int, name string) string {
+	return fmt.Sprintf("reduce_%d_output_%s.db", r, name)
+}
+
+// outputName returns the name of a named output file, or "" for a task's
+// main output
+func outputName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), ".db")
+	_, name, _ := strings.Cut(base, "_output_")
+	return name
+}
+
+// splitNamed separates the pairs headed for the main output from those
+// headed for named outputs
+func splitNamed(pairs []Pair) ([]Pair, map[string][]Pair, error) {
+	var unnamed []Pair
+	var named map[string][]Pair
+	for _, pair := range pairs {
+		if pair.Output == "" {
+			unnamed = append(unnamed, pair)
+			continue
+		}
+		if !outputNamePattern.MatchString(pair.Output) {
+			return nil, nil, fmt.Errorf("bad output name %q", pair.Output)
+		}
+		if named == nil {
+			named = make(map[string][]Pair)
+		}
+		named[pair.Output] = append(named[pair.Output], pair)
+	}
+	return unnamed, named, nil
+}
+
+// writeNamedOutputs writes each named output to its own pairs database in
+// dir and returns the files in name order
+func writeNamedOutputs(logger *slog.Logger, dir string, n int, named map[string][]Pair, file func(name string) string) ([]string, error) {
+	var names []string
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var paths []string
+	for _, name := range names {
+		path := filepath.Join(dir, file(name))
+		db, err := createDatabase(path)
+		if err != nil {
+			logger.Error("creating named output", "file", path, "err", err)
+			return nil, err
+		}
+		if err := InsertPair(n, n, db, named[name]); err != nil {
+			return nil, err
+		}
+		if err := db.Close(); err != nil {
+			return nil, err
+		}
+		if err := writeChecksum(path); err != nil {
+			logger.Error("recording checksum", "file", path, "err", err)
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// addOutputs files a task's output files under the main or a named output
+func (job *Job) addOutputs(paths []string) {
+	for _, path := range paths {
+		name := outputName(path)
+		if name == "" {
+			job.outputs = append(job.outputs, path)
+			continue
+		}
+		if job.named == nil {
+			job.named = make(map[string][]string)
+		}
+		job.named[name] = append(job.named[name], path)
+	}
+}
+
+// NamedOutputs returns the files of each named output of a finished job,
+// in task order
+func (job *Job) NamedOutputs() map[string][]string {
+	return job.named
+}
+
+// namedTarget is where Gather puts a named output: target.db becomes
+// target_<name>.db
+func namedTarget(target, name string) string {
+	return strings.TrimSuffix(target, ".db") + "_" + name + ".db"
+}
+
+// Gather merges a finished job's main output into target and each named
+// output into a target of its own beside it
+func (job *Job) Gather(target string) error {
+	if err := gatherOutputs(target, job.outputs); err != nil {
+		return err
+	}
+	var names []string
+	for name := range job.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := gatherOutputs(namedTarget(target, name), job.named[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gatherOutputs(target string, paths []string) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		slog.Error("deleting old target", "file", target, "err", err)
+		return err
+	}
+	db, err := createDatabase(target)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := mergeFrom(slog.Default(), db, path); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	slog.Info("gathered job output", "file", target, "inputs", len(paths))
+	return nil
+}
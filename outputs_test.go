@@ -0,0 +1,134 @@
+package mapreduce
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// tally counts each value, sending values seen once to the "singletons"
+// output
+type tally struct{}
+
+func (tally) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	output <- Pair{Key: value, Value: key}
+	return nil
+}
+
+func (tally) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	n := 0
+	for range values {
+		n++
+	}
+	if n == 1 {
+		output <- Pair{Key: key, Value: "1", Output: "singletons"}
+		return nil
+	}
+	output <- Pair{Key: key, Value: strconv.Itoa(n)}
+	return nil
+}
+
+// shortValues also sends one-digit values to the "short" output from Map
+type shortValues struct{ tally }
+
+func (shortValues) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	output <- Pair{Key: value, Value: key}
+	if len(value) == 1 {
+		output <- Pair{Key: value, Value: key, Output: "short"}
+	}
+	return nil
+}
+
+// tallyInput has 50 values six times each, and one value once
+func tallyInput() []Pair {
+	var input []Pair
+	for i := 0; i < 300; i++ {
+		input = append(input, Pair{Key: strconv.Itoa(i), Value: strconv.Itoa(i % 50)})
+	}
+	return append(input, Pair{Key: "x", Value: "lonely"})
+}
+
+func TestNamedOutputs(t *testing.T) {
+	job := &Job{Client: tally{}, M: 3, R: 4}
+	out := runJob(t, job, tallyInput())
+	if len(out) != 50 {
+		t.Errorf("main output has %d keys, want 50", len(out))
+	}
+	target := filepath.Join(t.TempDir(), "out.db")
+	if err := job.Gather(target); err != nil {
+		t.Fatal(err)
+	}
+	singles, err := readPairs(namedTarget(target, "singletons"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Pair{{Key: "lonely", Value: "1"}}; !reflect.DeepEqual(singles, want) {
+		t.Errorf("singletons output %v, want %v", singles, want)
+	}
+	// only the reduce task that wrote to it has a file for it
+	if names := job.NamedOutputs(); len(names) != 1 || len(names["singletons"]) != 1 {
+		t.Errorf("named outputs %v, want one singletons file", names)
+	}
+}
+
+func TestMapOnlyJob(t *testing.T) {
+	job := &Job{Client: shortValues{}, M: 3, R: 0}
+	out := runJob(t, job, tallyInput())
+	if len(out) != 301 {
+		t.Errorf("main output has %d pairs, want every record", len(out))
+	}
+	short, err := readOutputs(job.NamedOutputs()["short"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(short) != 60 {
+		t.Errorf("short output has %d pairs, want 60", len(short))
+	}
+	if job.outputPhase() != "map" {
+		t.Errorf("map-only job takes its output from the %s phase", job.outputPhase())
+	}
+
+	// named map outputs would be lost in a job with a reduce phase
+	address, data, stop := (&FakeCluster{}).driver()
+	defer stop()
+	dir := t.TempDir()
+	job = &Job{ID: "job", Sources: []string{writeSource(t, dir, tallyInput())}, M: 2, R: 1, TempDir: filepath.Join(dir, "job"),
+		Address: address, Client: shortValues{}, Status: newJobStatus("job", 2, 1)}
+	if err := runStage(job, data, nil); err == nil {
+		t.Error("Map wrote a named output in a job with reduce tasks")
+	}
+}
+
+func TestNamedOutputsAfterRerun(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := openJournal(filepath.Join(dir, "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	address, data, stop := (&FakeCluster{}).driver()
+	defer stop()
+	source := writeSource(t, dir, tallyInput())
+
+	run := func(client Interface) (*Job, error) {
+		job := &Job{ID: "job", Sources: []string{source}, M: 3, R: 2, TempDir: filepath.Join(dir, "job"),
+			Address: address, Client: client, Status: newJobStatus("job", 3, 2), Journal: journal}
+		return job, runStage(job, data, nil)
+	}
+	first, err := run(tally{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a finished job is not run again, so a failing client does not matter
+	again, err := run(failingClient{tally{}, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.NamedOutputs(), first.NamedOutputs()) || !reflect.DeepEqual(again.Outputs(), first.Outputs()) {
+		t.Errorf("rerun found outputs %v and %v, want %v and %v", again.Outputs(), again.NamedOutputs(), first.Outputs(), first.NamedOutputs())
+	}
+}
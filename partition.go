@@ -0,0 +1,118 @@
+package mapreduce
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+)
+
+// how many input records the driver runs through Map to pick range bounds
+const totalOrderSamples = 10000
+
+// partition picks the reduce task for a map output key: by range when the
+// task has bounds for a total order, otherwise by hash
+func (task *MapTask) partition(order keyOrder, key string) int {
+	group := order.group(key)
+	if task.Bounds == nil {
+		hash := fnv.New32()
+		hash.Write([]byte(group))
+		return int(hash.Sum32() % uint32(task.R))
+	}
+	// the first bound above the key; keys equal to a bound go after it
+	byKey := keyOrder{compare: order.compare}
+	return sort.Search(len(task.Bounds), func(i int) bool {
+		return byKey.less(group, task.Bounds[i])
+	})
+}
+
+// sampleBounds picks r-1 range bounds so that reduce outputs 0..r-1 read
+// in turn are in key order. It samples records from the map inputs, runs
+// them through Map, and takes evenly spaced grouping keys from the sorted
+// output, as TeraSort does. ctx is the job context Map gets.
+func sampleBounds(paths []string, client Interface, ctx *JobContext, r int) ([]string, error) {
+	logger := slog.Default().With("phase", "sample")
+	ctx.logger = logger
+	if err := configure(logger, client, ctx.Conf()); err != nil {
+		return nil, err
+	}
+	order := keyOrderOf(client)
+	setContext(client, ctx)
+	cleanup, err := setup(logger, client, 0, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	perSplit := (totalOrderSamples + len(paths) - 1) / len(paths)
+
+	var keys []string
+	collect := func(call func(output chan<- Pair) error) error {
+		output := make(chan Pair)
+		collected := make(chan bool)
+		go func() {
+			for pair := range output {
+				keys = append(keys, order.group(pair.Key))
+			}
+			collected <- true
+		}()
+		err := call(output)
+		<-collected
+		return err
+	}
+	for _, path := range paths {
+		db, err := openDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := db.Query("select key, value from pairs order by random() limit ?", perSplit)
+		if err != nil {
+			slog.Error("sampling map input", "file", path, "err", err)
+			db.Close()
+			return nil, err
+		}
+		var records []Pair
+		for rows.Next() {
+			var p Pair
+			if err := rows.Scan(&p.Key, &p.Value); err != nil {
+				rows.Close()
+				db.Close()
+				return nil, err
+			}
+			records = append(records, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		db.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			err := collect(func(output chan<- Pair) error {
+				return client.Map(record.Key, record.Value, output)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("sampling Map on key %q: %w", record.Key, err)
+			}
+		}
+	}
+	if err := flush(logger, client, collect); err != nil {
+		return nil, err
+	}
+	if err := cleanup(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(keys, func(a, b int) bool {
+		return keyOrder{compare: order.compare}.less(keys[a], keys[b])
+	})
+	// with nothing sampled every bound is "", so all keys share a partition
+	bounds := make([]string, r-1)
+	if len(keys) > 0 {
+		for i := range bounds {
+			bounds[i] = keys[(i+1)*len(keys)/r]
+		}
+	}
+	slog.Info("sampled range bounds", "samples", len(keys), "r", r)
+	return bounds, nil
+}
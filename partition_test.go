@@ -0,0 +1,47 @@
+package mapreduce
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestTotalOrder(t *testing.T) {
+	var input []Pair
+	for i := 0; i < 3000; i++ {
+		input = append(input, Pair{Key: strconv.Itoa(i), Value: strconv.Itoa(i * 7919 % 2003)})
+	}
+	for name, compare := range map[string]KeyComparator{
+		"bytes":           nil,
+		"numeric":         NumericKeys,
+		"reverse-numeric": NumericKeys.Reverse(),
+	} {
+		parts := partitions(t, &Stage{Client: WithKeyOrder(countValues{}, compare), M: 3, R: 4, TotalOrder: true}, input)
+		order := keyOrder{compare: compare}
+		var all []Pair
+		for r, part := range parts {
+			// the sample covers every key, so no range should be left empty
+			if len(part) == 0 {
+				t.Errorf("%s: partition %d is empty", name, r)
+			}
+			all = append(all, part...)
+		}
+		if len(all) != 2003 {
+			t.Errorf("%s: got %d keys, want 2003", name, len(all))
+		}
+		for i := 1; i < len(all); i++ {
+			if !order.less(all[i-1].Key, all[i].Key) {
+				t.Errorf("%s: %s comes before %s across the outputs", name, all[i-1].Key, all[i].Key)
+				break
+			}
+		}
+	}
+}
+
+func TestRangePartition(t *testing.T) {
+	task := &MapTask{R: 3, Bounds: []string{"g", "p"}}
+	for key, want := range map[string]int{"a": 0, "f": 0, "g": 1, "o": 1, "p": 2, "z": 2} {
+		if got := task.partition(keyOrder{}, key); got != want {
+			t.Errorf("%q goes to %d, want %d", key, got, want)
+		}
+	}
+}
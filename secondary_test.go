@@ -0,0 +1,68 @@
+package mapreduce
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sessions lists each user's events in time order, from records keyed by
+// event with "user time" values
+type sessions struct{}
+
+func (sessions) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	user, time, _ := strings.Cut(value, " ")
+	output <- Pair{Key: user + "|" + time, Value: key}
+	return nil
+}
+
+func (sessions) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	var events []string
+	for value := range values {
+		events = append(events, value)
+	}
+	output <- Pair{Key: key, Value: strings.Join(events, ",")}
+	return nil
+}
+
+// timedSessions asks for the same order by implementing
+// SecondarySortInterface
+type timedSessions struct{ sessions }
+
+func (timedSessions) SplitKey(key string) (string, string) { return SplitAt("|")(key) }
+func (timedSessions) CompareSortKeys(a, b string) int      { return NumericKeys(a, b) }
+
+func TestSecondarySort(t *testing.T) {
+	input := []Pair{
+		{Key: "login", Value: "bob 5"}, {Key: "view", Value: "bob 10"}, {Key: "buy", Value: "bob 100"},
+		{Key: "logout", Value: "bob 9"}, {Key: "start", Value: "Amy 3"}, {Key: "open", Value: "amy 1"},
+		{Key: "close", Value: "amy 20"}, {Key: "ping", Value: "cy 7"},
+	}
+	want := map[string]string{"Amy": "start", "amy": "open,close", "bob": "login,logout,view,buy", "cy": "ping"}
+	for name, client := range map[string]Interface{
+		"wrapped":   WithSecondarySort(sessions{}, SplitAt("|"), NumericKeys),
+		"interface": timedSessions{},
+	} {
+		got := make(map[string]string)
+		for _, part := range partitions(t, &Stage{Client: client, M: 3, R: 2}, input) {
+			for _, pair := range part {
+				if _, dup := got[pair.Key]; dup {
+					t.Errorf("%s: user %s reduced twice", name, pair.Key)
+				}
+				got[pair.Key] = pair.Value
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	// the grouping keys still follow the key order
+	client := WithKeyOrder(WithSecondarySort(sessions{}, SplitAt("|"), NumericKeys), CaseInsensitiveKeys.Reverse())
+	parts := partitions(t, &Stage{Client: client, M: 2, R: 1}, input)
+	if got, want := keysOf(parts[0]), []string{"cy", "bob", "amy", "Amy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("users in order %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,388 @@
+package mapreduce
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// a partition with more than skewFactor times the mean number of rows is
+// skewed; a key in one with more rows than the mean partition is hot
+const skewFactor = 2.0
+
+// CombiningInterface is implemented by Interface implementations whose
+// values can be partly reduced. Combine folds some of one key's values
+// into fewer values of the same form, which Reduce has to accept in their
+// place, and closes output when it is done, as Reduce does. Hot keys of a
+// job with a combiner are reduced in two stages: their values are salted
+// across several combine tasks, and the reduce task gets just the partial
+// values.
+type CombiningInterface interface {
+	Interface
+	Combine(key string, values <-chan string, output chan<- string) error
+}
+
+var errNoCombiner = errors.New("client has no Combine method")
+
+// findCombiner looks for Combine on client or any client it wraps
+func findCombiner(client Interface) (CombiningInterface, bool) {
+	return findClient[CombiningInterface](client)
+}
+
+type hotKey struct {
+	Key       string `json:"key"`
+	Partition int    `json:"partition"`
+	Rows      int64  `json:"rows"`
+	Action    string `json:"action"` // "salted", or why it went to one reduce task
+}
+
+// skewReport is what the driver found out about partition sizes after the
+// map phase, and what it did about it
+type skewReport struct {
+	Rows    []int64     `json:"partition_rows"`
+	Mean    float64     `json:"mean_rows"`
+	Max     int64       `json:"max_rows"`
+	Ratio   float64     `json:"max_over_mean"`
+	Skewed  []int       `json:"skewed_partitions"`
+	HotKeys []hotKey    `json:"hot_keys"`
+	Salts   map[int]int `json:"salts,omitempty"` // combine tasks per partition
+}
+
+// analyzeSkew counts the rows of every map output in dir by partition, and
+// in skewed partitions counts rows by grouping key to find the hot keys
+func analyzeSkew(dir string, m, r int, order keyOrder) (*skewReport, error) {
+	report := &skewReport{Rows: make([]int64, r)}
+	var total int64
+	for i := 0; i < m; i++ {
+		for p := 0; p < r; p++ {
+			rows, err := getNumberOfRows(filepath.Join(dir, mapOutputFile(i, p)))
+			if err != nil {
+				return nil, err
+			}
+			report.Rows[p] += int64(rows)
+			total += int64(rows)
+		}
+	}
+	report.Mean = float64(total) / float64(r)
+	for p, rows := range report.Rows {
+		report.Max = max(report.Max, rows)
+		if report.Mean > 0 && float64(rows) > skewFactor*report.Mean {
+			report.Skewed = append(report.Skewed, p)
+		}
+	}
+	if report.Mean > 0 {
+		report.Ratio = float64(report.Max) / report.Mean
+	}
+
+	for _, p := range report.Skewed {
+		counts := make(map[string]int64)
+		for i := 0; i < m; i++ {
+			if err := countKeys(filepath.Join(dir, mapOutputFile(i, p)), order, counts); err != nil {
+				return nil, err
+			}
+		}
+		for key, rows := range counts {
+			if float64(rows) > report.Mean {
+				report.HotKeys = append(report.HotKeys, hotKey{Key: key, Partition: p, Rows: rows})
+			}
+		}
+	}
+	sort.Slice(report.HotKeys, func(a, b int) bool {
+		if report.HotKeys[a].Rows != report.HotKeys[b].Rows {
+			return report.HotKeys[a].Rows > report.HotKeys[b].Rows
+		}
+		return report.HotKeys[a].Key < report.HotKeys[b].Key
+	})
+	return report, nil
+}
+
+// countKeys adds the rows of each grouping key in a pairs database to counts
+func countKeys(path string, order keyOrder, counts map[string]int64) error {
+	db, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query("select key, count(*) from pairs group by key")
+	if err != nil {
+		slog.Error("counting keys", "file", path, "err", err)
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var n int64
+		if err := rows.Scan(&key, &n); err != nil {
+			return err
+		}
+		counts[order.group(key)] += n
+	}
+	return rows.Err()
+}
+
+// plan decides which hot keys to salt and across how many combine tasks,
+// given whether the job can combine at all. It returns the salted keys by
+// partition.
+func (report *skewReport) plan(m int, combiner bool, order keyOrder) map[int][]string {
+	hotRows := make(map[int]int64)
+	salted := make(map[int][]string)
+	for i := range report.HotKeys {
+		hot := &report.HotKeys[i]
+		switch {
+		case !combiner:
+			hot.Action = "no combiner, reduced by one task"
+			slog.Warn("hot key will be reduced by one task because the job has no combiner",
+				"key", hot.Key, "partition", hot.Partition, "rows", hot.Rows)
+		case order.split != nil:
+			hot.Action = "secondary sort, reduced by one task"
+			slog.Warn("hot key will be reduced by one task because combining would lose its value order",
+				"key", hot.Key, "partition", hot.Partition, "rows", hot.Rows)
+		case m < 2:
+			hot.Action = "one map output, nothing to salt"
+		default:
+			hot.Action = "salted"
+			hotRows[hot.Partition] += hot.Rows
+			salted[hot.Partition] = append(salted[hot.Partition], hot.Key)
+		}
+	}
+	if len(salted) > 0 {
+		report.Salts = make(map[int]int)
+	}
+	for p, rows := range hotRows {
+		salts := int(math.Ceil(float64(rows) / report.Mean))
+		report.Salts[p] = min(m, max(2, salts))
+	}
+	return salted
+}
+
+func (report *skewReport) log() {
+	slog.Info("partition skew", "mean_rows", int64(report.Mean), "max_rows", report.Max,
+		"max_over_mean", strconv.FormatFloat(report.Ratio, 'f', 2, 64),
+		"skewed_partitions", len(report.Skewed), "hot_keys", len(report.HotKeys))
+	for _, hot := range report.HotKeys {
+		slog.Info("hot key", "key", hot.Key, "partition", hot.Partition, "rows", hot.Rows, "action", hot.Action)
+	}
+}
+
+// CombineTask is the first stage of reducing hot keys: it combines their
+// values from the map outputs whose task number is Salt modulo Salts, so
+// that a partition's hot keys are spread over Salts tasks
+type CombineTask struct {
+	M, R        int        // total number of map and reduce tasks
+	N           int        // partition, 0-based
+	Salt, Salts int        // which share of the map outputs to read
+	Keys        []string   // hot keys to combine
+	FilesHost   string     // address serving the job's side files
+	Files       []SideFile // side files to fetch before running
+	Conf        JobConf    // job parameters for Configure
+	SourceHosts []string   // address holding each map task's output
+	Worker      string     // address of the worker running the task, for logging
+	Attempt     int        // which try at the task this is, 1-based, for logging
+	Stats       TaskStats  // filled in by Process
+}
+
+func (task *CombineTask) Process(path string, client Interface) error {
+	logger := task.logger()
+	trace := new(taskTrace)
+	if err := configure(logger, client, task.Conf); err != nil {
+		return err
+	}
+	counters := NewCounters()
+	ctx, err := fetchSideFiles(logger, trace, task.FilesHost, path, task.Files)
+	if err != nil {
+		return err
+	}
+	ctx.phase, ctx.conf, ctx.logger = "combine", task.Conf, logger
+	ctx.counters = counters
+	setContext(client, ctx)
+	combiner, ok := findCombiner(client)
+	if !ok {
+		return errNoCombiner
+	}
+
+	var urls []string
+	for m := task.Salt; m < task.M; m += task.Salts {
+		urls = append(urls, makeURL(task.SourceHosts[m], mapOutputFile(m, task.N)))
+	}
+	inputFile := filepath.Join(path, combineInputFile(task.N, task.Salt))
+	db, bytes, err := mergeDatabases(logger, trace, urls, inputFile, filepath.Join(path, combineTempFile(task.N, task.Salt)))
+	if err != nil {
+		logger.Error("merging combine input", "err", err)
+		return err
+	}
+	defer func() {
+		db.Close()
+		os.Remove(inputFile)
+	}()
+	task.Stats.Bytes = bytes
+
+	cleanup, err := setup(logger, client, task.N, ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var outs []Pair
+	endCombine := trace.begin("combine", "keys", strconv.Itoa(len(task.Keys)))
+	for _, key := range task.Keys {
+		rows, err := db.Query("select value from pairs where key = ? order by value", key)
+		if err != nil {
+			logger.Error("select query from database to get values", "key", key, "err", err)
+			return err
+		}
+
+		output := make(chan string)
+		collected := make(chan bool)
+		go func() {
+			for value := range output {
+				outs = append(outs, Pair{Key: key, Value: value})
+			}
+			collected <- true
+		}()
+		values := make(chan string)
+		finished := make(chan error, 1)
+		go func() {
+			err := combiner.Combine(key, values, output)
+			<-collected
+			finished <- err
+		}()
+
+		err = nil
+		for rows.Next() {
+			var value string
+			if err = rows.Scan(&value); err != nil {
+				break
+			}
+			select {
+			case values <- value:
+			case err = <-finished:
+				if err == nil {
+					err = fmt.Errorf("Combine returned before reading all values for key %q", key)
+				}
+			}
+			if err != nil {
+				break
+			}
+			task.Stats.RecordsIn++
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		close(values)
+		if err == nil {
+			err = <-finished
+		}
+		if err != nil {
+			logger.Error("Combine", "key", key, "err", err)
+			return err
+		}
+	}
+	endCombine()
+	if err := cleanup(); err != nil {
+		return err
+	}
+
+	outputFile := filepath.Join(path, combineOutputFile(task.N, task.Salt))
+	out, err := createDatabase(outputFile)
+	if err != nil {
+		logger.Error("creating combine output", "file", outputFile, "err", err)
+		return err
+	}
+	endInsert := trace.begin("insert", "pairs", strconv.Itoa(len(outs)))
+	err = InsertPair(task.N, task.N, out, outs)
+	endInsert()
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := writeChecksum(outputFile); err != nil {
+		logger.Error("recording checksum", "file", outputFile, "err", err)
+		return err
+	}
+	task.Stats.RecordsOut = len(outs)
+	task.Stats.Counters = counters.Snapshot()
+	task.Stats.Spans = trace.Spans()
+	return nil
+}
+
+// mergeCombined swaps the raw values of the task's hot keys in its merged
+// input for the partial values the combine tasks produced
+func (task *ReduceTask) mergeCombined(logger *slog.Logger, trace *taskTrace, path string, db *sql.DB) (int64, error) {
+	for _, key := range task.Hot {
+		if _, err := db.Exec("delete from pairs where key = ?", key); err != nil {
+			logger.Error("dropping hot key from reduce input", "key", key, "err", err)
+			return 0, err
+		}
+	}
+	// a partition with salted keys always gets a reduce task of its own
+	partition := task.partitions()[0]
+	var bytes int64
+	temp := filepath.Join(path, reduceTempFile(task.N))
+	for salt, host := range task.CombineHosts {
+		u := makeURL(host, combineOutputFile(partition, salt))
+		end := trace.begin("download", "url", u)
+		err := download(logger, u, temp)
+		end()
+		if err != nil {
+			return 0, err
+		}
+		if info, err := os.Stat(temp); err == nil {
+			bytes += info.Size()
+		}
+		end = trace.begin("gather", "url", u)
+		err = gatherInto(logger, db, temp)
+		end()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return bytes, nil
+}
+
+// runCombines runs the combine tasks for the hot keys of every reduce
+// task that still has to run, and points those reduce tasks at the output
+func (job *Job) runCombines(reduceTasks []*ReduceTask, report *skewReport, done map[int]finishedTask) error {
+	var tasks []*CombineTask
+	for i, reduce := range reduceTasks {
+		if _, ok := done[i]; ok || len(reduce.Hot) == 0 {
+			continue
+		}
+		p := reduce.Partitions[0]
+		for salt := 0; salt < report.Salts[p]; salt++ {
+			tasks = append(tasks, &CombineTask{
+				M:           job.M,
+				R:           job.R,
+				N:           p,
+				Salt:        salt,
+				Salts:       report.Salts[p],
+				Keys:        reduce.Hot,
+				FilesHost:   reduce.FilesHost,
+				Files:       reduce.Files,
+				Conf:        reduce.Conf,
+				SourceHosts: reduce.SourceHosts,
+				Worker:      job.Address,
+			})
+			reduce.CombineHosts = append(reduce.CombineHosts, job.Address)
+		}
+	}
+
+	job.Status.setCombines(len(tasks))
+	for i, task := range tasks {
+		task.Attempt = job.Status.start("combine", i, job.Address)
+		err := task.Process(job.TempDir, job.Client)
+		job.Status.finish("combine", i, task.Stats, err)
+		if err != nil {
+			return fmt.Errorf("combine task %d of partition %d: %w", task.Salt, task.N, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package mapreduce
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// noCombine hides the Combine method of the client it holds
+type noCombine struct{ Interface }
+
+// runJob runs job over input as the driver would, filling in where it runs,
+// and returns its outputs in order
+func runJob(t *testing.T, job *Job, input []Pair) []Pair {
+	t.Helper()
+	dir := t.TempDir()
+	address, data, stop := (&FakeCluster{}).driver()
+	defer stop()
+	job.ID, job.Sources, job.TempDir, job.Address = "job", []string{writeSource(t, dir, input)}, filepath.Join(dir, "job"), address
+	job.Status = newJobStatus("job", job.M, job.R)
+	if err := runStage(job, data, nil); err != nil {
+		t.Fatal(err)
+	}
+	out, err := readOutputs(job.Outputs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestHotKeys(t *testing.T) {
+	var input []Pair
+	for i := 0; i < 2000; i++ {
+		line := "the the the and"
+		if i%10 == 0 {
+			line += fmt.Sprintf(" w%d", i)
+		}
+		input = append(input, Pair{Key: fmt.Sprint(i), Value: line})
+	}
+
+	job := &Job{Client: &Client{}, M: 5, R: 4}
+	salted, status := runJob(t, job, input), job.Status
+	if status.Skew == nil || len(status.Skew.HotKeys) == 0 {
+		t.Fatalf("no hot keys found: %+v", status.Skew)
+	}
+	hot := status.Skew.HotKeys[0]
+	if hot.Key != "the" || hot.Rows != 6000 || hot.Action != "salted" {
+		t.Errorf("hottest key %+v, want the salted with 6000 rows", hot)
+	}
+	if len(status.Combines) < 2 {
+		t.Errorf("ran %d combine tasks, want the hot key spread over several", len(status.Combines))
+	}
+
+	job = &Job{Client: noCombine{&Client{}}, M: 5, R: 4}
+	plain, status := runJob(t, job, input), job.Status
+	if len(status.Combines) != 0 || status.Skew.HotKeys[0].Action != "no combiner, reduced by one task" {
+		t.Errorf("job without a combiner: %d combine tasks, hot key %+v", len(status.Combines), status.Skew.HotKeys[0])
+	}
+	byKey := func(pairs []Pair) {
+		sort.Slice(pairs, func(a, b int) bool { return pairs[a].Key < pairs[b].Key })
+	}
+	byKey(salted)
+	byKey(plain)
+	if !reflect.DeepEqual(salted, plain) {
+		t.Errorf("salting changed the output")
+	}
+	if len(plain) != 202 {
+		t.Errorf("got %d words, want 202", len(plain))
+	}
+}
@@ -0,0 +1,260 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// JobSpec holds the settings that decide what a job computes, as given by
+// the command line flags or a job spec file. It is journaled with the job,
+// so a resume runs the job it started rather than whatever the flags say
+// this time.
+type JobSpec struct {
+	Sources       []string `json:"sources"`
+	Join          string   `json:"join,omitempty"`      // join kind; "" counts words instead
+	KeyOrder      string   `json:"key_order,omitempty"` // "" is bytes
+	TotalOrder    bool     `json:"total_order,omitempty"`
+	CoalesceBytes int64    `json:"coalesce_bytes"`
+	Lookup        string   `json:"lookup,omitempty"`
+	Files         []string `json:"files,omitempty"`
+	Conf          JobConf  `json:"conf,omitempty"`
+	Mapper        string   `json:"mapper,omitempty"`
+	Reducer       string   `json:"reducer,omitempty"`
+}
+
+// client builds the Interface the spec asks for
+func (s *JobSpec) client() (Interface, error) {
+	name := s.KeyOrder
+	if name == "" {
+		name = "bytes"
+	}
+	order, err := parseKeyOrder(name)
+	if err != nil {
+		return nil, err
+	}
+	streaming := s.Mapper != "" || s.Reducer != ""
+	var client Interface = &Client{}
+	switch {
+	case s.Join != "" && streaming:
+		return nil, errors.New("a join can't also run a mapper or reducer")
+	case s.Join != "":
+		kind, err := parseJoinKind(s.Join)
+		if err != nil {
+			return nil, err
+		}
+		client = &Join{Kind: kind, Sides: len(s.Sources)}
+	case streaming:
+		client = &Streaming{Mapper: s.Mapper, Reducer: s.Reducer}
+	}
+	return WithKeyOrder(client, order), nil
+}
+
+// apply sets up job to run as the spec says
+func (s *JobSpec) apply(job *Job) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	job.Client = client
+	job.TotalOrder = s.TotalOrder
+	job.CoalesceBytes = s.CoalesceBytes
+	job.Lookup = s.Lookup
+	job.Files = s.Files
+	job.Conf = s.Conf
+	job.Spec = s
+	return nil
+}
+
+// same reports whether two specs ask for the same job
+func (s *JobSpec) same(other *JobSpec) bool {
+	a, errA := json.Marshal(s)
+	b, errB := json.Marshal(other)
+	return errA == nil && errB == nil && string(a) == string(b)
+}
+
+// SpecFile is a job spec file, read by the -spec flag, describing either
+// a DAG of stages or an iterative job. Each stage, and the iterated job,
+// takes the settings of a JobSpec alongside its own.
+//
+//	{
+//		"id": "bigrams",
+//		"stages": [
+//			{"name": "count", "m": 8, "r": 4, "sources": ["austen.db"], "mapper": "./bigrams.py", "files": ["bigrams.py"]},
+//			{"name": "top", "m": 4, "r": 1, "parents": ["count"], "key_order": "reverse-numeric", "reducer": "head -100"}
+//		]
+//	}
+//
+// The ID names the run in the journal and its temp dir, so running a spec
+// file again picks up where the last run with that ID stopped, and returns
+// at once if it finished.
+type SpecFile struct {
+	ID      string       `json:"id"`
+	Stages  []*StageSpec `json:"stages,omitempty"`
+	Iterate *IterateSpec `json:"iterate,omitempty"`
+}
+
+// StageSpec is one stage of a DAG in a job spec file
+type StageSpec struct {
+	Name    string   `json:"name"`
+	M       int      `json:"m"`
+	R       int      `json:"r"`
+	Parents []string `json:"parents,omitempty"`
+	JobSpec
+}
+
+// IterateSpec is an iterative job in a job spec file. It stops after
+// MaxIterations, or sooner once Counter is at or below Threshold.
+type IterateSpec struct {
+	M             int    `json:"m"`
+	R             int    `json:"r"`
+	MaxIterations int    `json:"max_iterations"`
+	Keep          int    `json:"keep,omitempty"`
+	Counter       string `json:"counter,omitempty"`
+	Threshold     int64  `json:"threshold,omitempty"`
+	JobSpec
+}
+
+var specIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+func loadSpecFile(path string) (*SpecFile, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var f SpecFile
+	decoder := json.NewDecoder(fp)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("reading job spec %s: %w", path, err)
+	}
+	if !specIDPattern.MatchString(f.ID) {
+		return nil, fmt.Errorf("job spec %s: bad id %q", path, f.ID)
+	}
+	if (len(f.Stages) == 0) == (f.Iterate == nil) {
+		return nil, fmt.Errorf("job spec %s needs either stages or iterate", path)
+	}
+	return &f, nil
+}
+
+// dag builds the DAG a spec file describes, run in dir
+func (f *SpecFile) dag(dir string) (*DAG, error) {
+	d := &DAG{ID: f.ID, Dir: dir}
+	for _, s := range f.Stages {
+		client, err := s.client()
+		if err != nil {
+			return nil, fmt.Errorf("stage %q: %w", s.Name, err)
+		}
+		if join, ok := findClient[*Join](client); ok {
+			join.Sides = len(s.Sources) + len(s.Parents)
+		}
+		d.Stages = append(d.Stages, &Stage{
+			Name:    s.Name,
+			Client:  client,
+			M:       s.M,
+			R:       s.R,
+			Sources: s.Sources,
+			Parents: s.Parents,
+
+			TotalOrder:    s.TotalOrder,
+			CoalesceBytes: s.CoalesceBytes,
+			Lookup:        s.Lookup,
+			Files:         s.Files,
+			Conf:          s.Conf,
+			Spec:          &s.JobSpec,
+		})
+	}
+	return d, nil
+}
+
+// iterative builds the iterative job a spec file describes, run in dir
+func (f *SpecFile) iterative(dir string) (*Iterative, error) {
+	s := f.Iterate
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	return &Iterative{
+		ID:      f.ID,
+		Dir:     dir,
+		Client:  client,
+		M:       s.M,
+		R:       s.R,
+		Sources: s.Sources,
+
+		MaxIterations: s.MaxIterations,
+		Keep:          s.Keep,
+		Counter:       s.Counter,
+		Threshold:     s.Threshold,
+
+		TotalOrder:    s.TotalOrder,
+		CoalesceBytes: s.CoalesceBytes,
+		Lookup:        s.Lookup,
+		Files:         s.Files,
+		Conf:          s.Conf,
+		Spec:          &s.JobSpec,
+	}, nil
+}
+
+// runSpecFile runs the job a spec file describes on this node's server,
+// pointing data and status at each stage or iteration in turn, and gathers
+// its result into target: the final iteration's outputs, or each sink
+// stage's, into target_<stage>.db when there are several. The outputs are
+// left in the temp dir for a later run of the same ID.
+func runSpecFile(path, address, target string, data, status *handlerSwitch, j *journal) error {
+	f, err := loadSpecFile(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("mapreduce.%s", f.ID))
+	slog.Info("running job spec", "file", path, "id", f.ID, "dir", dir)
+
+	results := make(map[string][]string)
+	if f.Iterate != nil {
+		it, err := f.iterative(dir)
+		if err != nil {
+			return err
+		}
+		it.Address, it.Data, it.Status, it.Journal = address, data, status, j
+		outputs, n, err := it.Run()
+		if err != nil {
+			return err
+		}
+		slog.Info("iterative job finished", "id", f.ID, "iterations", n)
+		results[target] = outputs
+	} else {
+		d, err := f.dag(dir)
+		if err != nil {
+			return err
+		}
+		d.Address, d.Data, d.Status, d.Journal = address, data, status, j
+		outputs, err := d.Run()
+		if err != nil {
+			return err
+		}
+		sinks := d.sinks()
+		for _, s := range sinks {
+			name := target
+			if len(sinks) > 1 {
+				name = namedTarget(target, s.Name)
+			}
+			results[name] = outputs[s.Name]
+		}
+	}
+
+	if target == "" {
+		slog.Info("job outputs left in place", "dir", dir)
+		return nil
+	}
+	for name, outputs := range results {
+		if err := gatherOutputs(name, outputs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
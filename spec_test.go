@@ -0,0 +1,103 @@
+package mapreduce
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// runSpec writes a job spec file into dir and runs it into target there
+func runSpec(t *testing.T, dir, spec string, j *journal) (string, error) {
+	t.Helper()
+	path := filepath.Join(dir, "spec.json")
+	if err := os.WriteFile(path, []byte(spec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	address, data, stop := (&FakeCluster{}).driver()
+	defer stop()
+	target := filepath.Join(dir, "out.db")
+	return target, runSpecFile(path, address, target, data, new(handlerSwitch), j)
+}
+
+func TestSpecFileDAG(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	journal, err := openJournal(filepath.Join(dir, "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	source := writeSource(t, dir, lines(10, "the cat and the dog"))
+
+	spec := `{"id": "shout", "stages": [
+		{"name": "count", "m": 2, "r": 2, "sources": ["` + source + `"]},
+		{"name": "upper", "m": 2, "r": 1, "parents": ["count"], "mapper": "tr a-z A-Z"},
+		{"name": "numeric", "m": 1, "r": 1, "parents": ["count"], "key_order": "reverse-bytes"}
+	]}`
+	target, err := runSpec(t, dir, spec, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper, err := readPairs(namedTarget(target, "upper"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "AND", Value: "10"}, {Key: "CAT", Value: "10"}, {Key: "DOG", Value: "10"}, {Key: "THE", Value: "20"}}
+	if !reflect.DeepEqual(upper, want) {
+		t.Errorf("upper stage gave %v, want %v", upper, want)
+	}
+	if _, err := os.Stat(namedTarget(target, "numeric")); err != nil {
+		t.Errorf("second sink was not gathered: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mapreduce.shout", "count")); err != nil {
+		t.Errorf("stage outputs were not left in the spec's temp dir: %v", err)
+	}
+
+	// the same ID with other settings is refused rather than mixed in
+	spec = strings.Replace(spec, "tr a-z A-Z", "tr a-z B-Z", 1)
+	if _, err := runSpec(t, dir, spec, journal); err == nil || !strings.Contains(err.Error(), "different settings") {
+		t.Errorf("rerun with a changed stage gave %v, want the settings refused", err)
+	}
+}
+
+func TestSpecFileIterate(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	source := writeSource(t, dir, numbers(5, 1))
+
+	spec := `{"id": "passes", "iterate": {"m": 2, "r": 2, "max_iterations": 3, "sources": ["` + source + `"], "reducer": "cat"}}`
+	target, err := runSpec(t, dir, spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := readPairs(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the target holds each reduce partition in turn
+	sort.Slice(out, func(a, b int) bool { return out[a].Key < out[b].Key })
+	if !reflect.DeepEqual(out, numbers(5, 1)) {
+		t.Errorf("three passes through cat gave %v, want the source", out)
+	}
+}
+
+func TestSpecFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, spec := range map[string]string{
+		"both":          `{"id": "x", "stages": [{"name": "a", "m": 1}], "iterate": {"m": 1}}`,
+		"neither":       `{"id": "x"}`,
+		"bad id":        `{"id": "../x", "iterate": {"m": 1}}`,
+		"unknown field": `{"id": "x", "iterate": {"m": 1, "maps": 3}}`,
+	} {
+		path := filepath.Join(dir, "spec.json")
+		if err := os.WriteFile(path, []byte(spec), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSpecFile(path); err == nil {
+			t.Errorf("%s: spec file loaded", name)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// what a task did, filled in by Process
+type TaskStats struct {
+	RecordsIn  int   `json:"records_in"`  // pairs read from the task's input
+	RecordsOut int   `json:"records_out"` // pairs written to the task's output
+	Bytes      int64 `json:"bytes"`       // shuffle bytes written (map) or fetched (reduce)
+
+	Counters map[string]int64 `json:"counters,omitempty"` // user counters from Map or Reduce
+	Spans    []Span           `json:"-"`                  // timed phases, for the trace file
+}
+
+const (
+	taskPending = "pending"
+	taskRunning = "running"
+	taskDone    = "done"
+	taskFailed  = "failed"
+)
+
+type taskStatus struct {
+	Phase    string    `json:"phase"`
+	N        int       `json:"n"`
+	State    string    `json:"state"`
+	Worker   string    `json:"worker,omitempty"`
+	Attempts int       `json:"attempts"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	Duration float64   `json:"duration_seconds"`
+	Error    string    `json:"error,omitempty"`
+
+	Partitions []int `json:"partitions,omitempty"` // what a reduce task covers
+	TaskStats
+}
+
+// jobStatus tracks every task in a job for the /status endpoint
+type jobStatus struct {
+	mu      sync.Mutex
+	Source  string        `json:"source"`
+	M       int           `json:"m"`
+	R       int           `json:"r"`
+	Started time.Time     `json:"started"`
+	Maps    []*taskStatus `json:"maps"`
+	Reduces []*taskStatus `json:"reduces"`
+
+	// first stage of reducing hot keys, added once the map phase is done
+	Combines []*taskStatus `json:"combines,omitempty"`
+	Skew     *skewReport   `json:"skew,omitempty"`
+
+	Counters *Counters `json:"counters"` // user counters from every finished task
+}
+
+func newJobStatus(source string, m, r int) *jobStatus {
+	js := &jobStatus{Source: source, M: m, R: r, Started: time.Now(), Counters: NewCounters()}
+	for i := 0; i < m; i++ {
+		js.Maps = append(js.Maps, &taskStatus{Phase: "map", N: i, State: taskPending})
+	}
+	for i := 0; i < r; i++ {
+		js.Reduces = append(js.Reduces, &taskStatus{Phase: "reduce", N: i, State: taskPending})
+	}
+	return js
+}
+
+func (js *jobStatus) task(phase string, n int) *taskStatus {
+	switch phase {
+	case "map":
+		return js.Maps[n]
+	case "combine":
+		return js.Combines[n]
+	}
+	return js.Reduces[n]
+}
+
+// setSkew records the skew analysis done after the map phase
+func (js *jobStatus) setSkew(report *skewReport) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.Skew = report
+}
+
+// setCombines adds a pending task for each combine task planned
+func (js *jobStatus) setCombines(n int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.Combines = nil
+	for i := 0; i < n; i++ {
+		js.Combines = append(js.Combines, &taskStatus{Phase: "combine", N: i, State: taskPending})
+	}
+}
+
+// setReduces lays out the reduce tasks once the partitions they cover are
+// known
+func (js *jobStatus) setReduces(groups [][]int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.Reduces = nil
+	for i, group := range groups {
+		js.Reduces = append(js.Reduces, &taskStatus{Phase: "reduce", N: i, State: taskPending, Partitions: group})
+	}
+}
+
+// start marks a task as running and returns which attempt this is
+func (js *jobStatus) start(phase string, n int, worker string) int {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	t := js.task(phase, n)
+	t.State = taskRunning
+	t.Worker = worker
+	t.Attempts++
+	t.Started = time.Now()
+	t.Finished = time.Time{}
+	t.Error = ""
+	return t.Attempts
+}
+
+// restore marks a task that finished in an earlier run of the job
+func (js *jobStatus) restore(phase string, n int, previous finishedTask) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	t := js.task(phase, n)
+	t.State = taskDone
+	t.Worker = previous.Worker
+	t.Counters = previous.Counters
+	js.Counters.Merge(previous.Counters)
+}
+
+func (js *jobStatus) finish(phase string, n int, stats TaskStats, err error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	t := js.task(phase, n)
+	t.Finished = time.Now()
+	t.Duration = t.Finished.Sub(t.Started).Seconds()
+	t.TaskStats = stats
+	recordTaskMetrics(phase, stats, err)
+	if err != nil {
+		t.State = taskFailed
+		t.Error = err.Error()
+	} else {
+		t.State = taskDone
+		js.Counters.Merge(stats.Counters)
+	}
+}
+
+func (js *jobStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	// running tasks report how long they have been going so far
+	now := time.Now()
+	for _, list := range [][]*taskStatus{js.Maps, js.Combines, js.Reduces} {
+		for _, t := range list {
+			if t.State == taskRunning {
+				t.Duration = now.Sub(t.Started).Seconds()
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(js); err != nil {
+		slog.Error("encoding job status", "err", err)
+	}
+}
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+// the dashboard polls /status once a second and redraws the task tables
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mapreduce job</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: right; }
+th { background: #eee; }
+.pending { color: #888; }
+.running { background: #ffd; }
+.done { background: #dfd; }
+.failed { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>mapreduce job</h1>
+<p id="summary"></p>
+<h2>map tasks</h2>
+<table id="maps"></table>
+<div id="skew"></div>
+<h2>reduce tasks</h2>
+<table id="reduces"></table>
+<script>
+function row(cells, tag) {
+	var tr = document.createElement("tr");
+	cells.forEach(function (c) {
+		var td = document.createElement(tag || "td");
+		td.textContent = c;
+		tr.appendChild(td);
+	});
+	return tr;
+}
+function draw(id, tasks) {
+	var table = document.getElementById(id);
+	table.innerHTML = "";
+	table.appendChild(row(["N", "state", "worker", "attempts", "seconds", "records in", "records out", "bytes shuffled", "error"], "th"));
+	tasks.forEach(function (t) {
+		var tr = row([t.n, t.state, t.worker || "", t.attempts, t.duration_seconds.toFixed(2),
+			t.records_in, t.records_out, t.bytes, t.error || ""]);
+		tr.className = t.state;
+		table.appendChild(tr);
+	});
+}
+function drawSkew(skew, combines) {
+	var div = document.getElementById("skew");
+	div.innerHTML = "";
+	if (!skew) {
+		return;
+	}
+	var h = document.createElement("h2");
+	h.textContent = "partition skew";
+	div.appendChild(h);
+	var p = document.createElement("p");
+	p.textContent = "largest partition " + skew.max_rows + " rows, " + skew.max_over_mean.toFixed(2) +
+		" times the mean; " + (skew.skewed_partitions || []).length + " skewed partitions";
+	div.appendChild(p);
+	if ((skew.hot_keys || []).length > 0) {
+		var table = document.createElement("table");
+		table.appendChild(row(["key", "partition", "rows", "action"], "th"));
+		skew.hot_keys.forEach(function (k) {
+			table.appendChild(row([k.key, k.partition, k.rows, k.action]));
+		});
+		div.appendChild(table);
+	}
+	if (combines.length > 0) {
+		var h2 = document.createElement("h2");
+		h2.textContent = "combine tasks";
+		div.appendChild(h2);
+		var t = document.createElement("table");
+		t.id = "combines";
+		div.appendChild(t);
+		draw("combines", combines);
+	}
+}
+function count(tasks, state) {
+	return tasks.filter(function (t) { return t.state === state; }).length;
+}
+function refresh() {
+	fetch("/status").then(function (res) { return res.json(); }).then(function (job) {
+		document.getElementById("summary").textContent =
+			job.source + ": " + count(job.maps, "done") + "/" + job.m + " maps and " +
+			count(job.reduces, "done") + "/" + job.reduces.length + " reduces done";
+		draw("maps", job.maps);
+		drawSkew(job.skew, job.combines || []);
+		draw("reduces", job.reduces);
+	}).catch(function () {
+		document.getElementById("summary").textContent = "job is no longer running";
+	});
+}
+refresh();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
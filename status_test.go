@@ -0,0 +1,72 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusJSON(t *testing.T) {
+	js := newJobStatus("austen.db", 2, 1)
+	js.start("map", 0, "w1")
+	js.finish("map", 0, TaskStats{RecordsIn: 10, RecordsOut: 30, Counters: map[string]int64{"words": 30}}, nil)
+	js.start("map", 1, "w2")
+	js.finish("map", 1, TaskStats{}, errors.New("disk full"))
+	if attempt := js.start("map", 1, "w1"); attempt != 2 {
+		t.Errorf("retry is attempt %d, want 2", attempt)
+	}
+
+	rec := httptest.NewRecorder()
+	js.ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type %q", ct)
+	}
+	var got struct {
+		Source   string           `json:"source"`
+		Maps     []taskStatus     `json:"maps"`
+		Reduces  []taskStatus     `json:"reduces"`
+		Counters map[string]int64 `json:"counters"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%v in %s", err, rec.Body)
+	}
+	if got.Source != "austen.db" || len(got.Maps) != 2 || len(got.Reduces) != 1 {
+		t.Fatalf("status %s", rec.Body)
+	}
+	if m := got.Maps[0]; m.State != taskDone || m.Worker != "w1" || m.RecordsIn != 10 || m.RecordsOut != 30 {
+		t.Errorf("finished map %+v", m)
+	}
+	// a retried task shows as running again, without the last error
+	if m := got.Maps[1]; m.State != taskRunning || m.Attempts != 2 || m.Error != "" {
+		t.Errorf("retried map %+v", m)
+	}
+	if got.Reduces[0].State != taskPending {
+		t.Errorf("reduce %+v, want pending", got.Reduces[0])
+	}
+	if got.Counters["words"] != 30 {
+		t.Errorf("counters %v", got.Counters)
+	}
+}
+
+func TestStatusAfterJob(t *testing.T) {
+	fc := &FakeCluster{M: 3, R: 2, MaxAttempts: 2, Failures: []Failure{{Kind: FailTask, Phase: "map", Task: 1}}}
+	if _, err := fc.Run(&Client{}, lines(30, "the cat")); err != nil {
+		t.Fatal(err)
+	}
+	records := 0
+	for _, task := range append(fc.Status.Maps, fc.Status.Reduces...) {
+		if task.State != taskDone {
+			t.Errorf("%s %d is %s", task.Phase, task.N, task.State)
+		}
+		if task.Phase == "map" {
+			records += task.RecordsIn
+		}
+	}
+	if records != 30 {
+		t.Errorf("maps read %d records, want 30", records)
+	}
+	if fc.Status.Maps[1].Attempts != 2 {
+		t.Errorf("failed map took %d attempts, want 2", fc.Status.Maps[1].Attempts)
+	}
+}
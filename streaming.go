@@ -0,0 +1,271 @@
+package mapreduce
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Streaming is an Interface that runs external commands as the mapper and
+// reducer, in the style of Hadoop streaming, so map and reduce steps can be
+// written in any language. Each task starts its command once through sh -c
+// and writes every record to its stdin as a key<tab>value line; a reducer
+// reads its task's keys in order, with all the values of a key together.
+// Every line the command writes to stdout becomes an output pair, split at
+// the first tab, and a line without a tab is a key with an empty value.
+// Lines the command writes to stderr go to the task log, and a non-zero
+// exit status fails the task.
+//
+// The command runs in the directory holding the job's side files, if it
+// has any, so a script shipped with Job.Files can be run by name. It gets
+// the phase and task number as MAPREDUCE_PHASE and MAPREDUCE_TASK, and
+// every job parameter as an environment variable named after its key, with
+// anything other than letters, digits and underscores made an underscore.
+//
+// Setup starts the task's command and Cleanup stops it, so a Streaming has
+// to be used through a pointer and by one task at a time, as a worker runs
+// them.
+type Streaming struct {
+	Mapper  string // command for map tasks; "" passes records through
+	Reducer string // command for reduce tasks; "" passes values through
+
+	proc *streamProcess // the running task's command, from Setup
+}
+
+func (s *Streaming) command(phase string) (string, string) {
+	if phase == "reduce" {
+		return "reducer", s.Reducer
+	}
+	return "mapper", s.Mapper
+}
+
+func (s *Streaming) Setup(task int, ctx *JobContext) error {
+	s.proc = nil
+	name, command := s.command(ctx.Phase())
+	if command == "" {
+		return nil
+	}
+	s.proc = &streamProcess{}
+	return s.proc.start(name, command, task, ctx)
+}
+
+func (s *Streaming) Cleanup(task int, ctx *JobContext) error {
+	if s.proc != nil {
+		s.proc.stop()
+		s.proc = nil
+	}
+	return nil
+}
+
+func (s *Streaming) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	if s.Mapper == "" {
+		output <- Pair{Key: key, Value: value}
+		return nil
+	}
+	if s.proc == nil || s.proc.cmd == nil {
+		return errors.New("streaming mapper is not running; Map was called outside a task")
+	}
+	if err := s.proc.write(key, value); err != nil {
+		return err
+	}
+	s.proc.drain(output)
+	return nil
+}
+
+func (s *Streaming) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	if s.Reducer == "" {
+		for value := range values {
+			output <- Pair{Key: key, Value: value}
+		}
+		return nil
+	}
+	if s.proc == nil || s.proc.cmd == nil {
+		return errors.New("streaming reducer is not running; Reduce was called outside a task")
+	}
+	for value := range values {
+		if err := s.proc.write(key, value); err != nil {
+			return err
+		}
+	}
+	s.proc.drain(output)
+	return nil
+}
+
+// Flush waits for the command to finish and hands on what it wrote after
+// the last record
+func (s *Streaming) Flush(output chan<- Pair) error {
+	defer close(output)
+	if s.proc == nil || s.proc.cmd == nil {
+		return nil
+	}
+	err := s.proc.finish()
+	s.proc.drain(output)
+	return err
+}
+
+// how much of each line a command writes to stderr goes to the task log
+const stderrLineLimit = 4 << 10
+
+// streamProcess is one task's run of a streaming command
+type streamProcess struct {
+	name, command string
+	cmd           *exec.Cmd
+	stdin         io.WriteCloser
+	in            *bufio.Writer
+	readers       sync.WaitGroup // stdout and stderr readers
+
+	mu      sync.Mutex
+	out     []Pair // read from stdout and not yet handed on
+	readErr error
+
+	finished bool
+	err      error // from finish
+}
+
+func (p *streamProcess) start(name, command string, task int, ctx *JobContext) error {
+	p.name, p.command = name, command
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = ctx.Dir()
+	cmd.Env = append(os.Environ(), streamEnv(task, ctx)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s %q: %w", name, command, err)
+	}
+	p.cmd, p.stdin, p.in = cmd, stdin, bufio.NewWriter(stdin)
+
+	logger := ctx.Logger().With("command", command)
+	p.readers.Add(2)
+	go func() {
+		defer p.readers.Done()
+		lines := bufio.NewReader(stdout)
+		for {
+			line, err := lines.ReadString('\n')
+			if line = strings.TrimSuffix(line, "\n"); line != "" || err == nil {
+				key, value, _ := strings.Cut(line, "\t")
+				p.mu.Lock()
+				p.out = append(p.out, Pair{Key: key, Value: value})
+				p.mu.Unlock()
+			}
+			if err != nil {
+				if err != io.EOF {
+					p.mu.Lock()
+					p.readErr = err
+					p.mu.Unlock()
+				}
+				return
+			}
+		}
+	}()
+	go func() {
+		defer p.readers.Done()
+		// the pipe is read to the end whatever comes through it, or the
+		// command could block writing to it; only the start of a long line
+		// is logged
+		lines := bufio.NewReaderSize(stderr, stderrLineLimit)
+		for {
+			line, more, err := lines.ReadLine()
+			if err != nil {
+				return
+			}
+			if !more {
+				logger.Info(name+" stderr", "line", string(line))
+				continue
+			}
+			logger.Info(name+" stderr", "line", string(line), "truncated", true)
+			for more && err == nil {
+				_, more, err = lines.ReadLine()
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *streamProcess) write(key, value string) error {
+	if strings.ContainsAny(key, "\t\n") || strings.Contains(value, "\n") {
+		return fmt.Errorf("record %q can't be passed to the %s: keys can't hold tabs or newlines and values can't hold newlines", key, p.name)
+	}
+	if _, err := p.in.WriteString(key + "\t" + value + "\n"); err != nil {
+		// the command has most likely exited, which says more
+		if exitErr := p.finish(); exitErr != nil {
+			return exitErr
+		}
+		return fmt.Errorf("writing to %s %q: %w", p.name, p.command, err)
+	}
+	return nil
+}
+
+// drain hands on every pair the command has written so far
+func (p *streamProcess) drain(output chan<- Pair) {
+	p.mu.Lock()
+	out := p.out
+	p.out = nil
+	p.mu.Unlock()
+	for _, pair := range out {
+		output <- pair
+	}
+}
+
+// finish closes the command's stdin and waits for it to exit
+func (p *streamProcess) finish() error {
+	if p.finished {
+		return p.err
+	}
+	p.finished = true
+	flushErr := p.in.Flush()
+	p.stdin.Close()
+	p.readers.Wait()
+	if err := p.cmd.Wait(); err != nil {
+		p.err = fmt.Errorf("%s %q: %w", p.name, p.command, err)
+	} else if p.readErr != nil {
+		p.err = fmt.Errorf("reading from %s %q: %w", p.name, p.command, p.readErr)
+	} else if flushErr != nil {
+		p.err = fmt.Errorf("writing to %s %q: %w", p.name, p.command, flushErr)
+	}
+	return p.err
+}
+
+// stop kills the command if the task failed before Flush
+func (p *streamProcess) stop() {
+	if p.cmd == nil || p.finished {
+		return
+	}
+	p.cmd.Process.Kill()
+	p.finish()
+}
+
+func streamEnv(task int, ctx *JobContext) []string {
+	env := []string{
+		"MAPREDUCE_PHASE=" + ctx.Phase(),
+		"MAPREDUCE_TASK=" + strconv.Itoa(task),
+	}
+	conf := ctx.Conf()
+	for _, key := range conf.keys() {
+		name := strings.Map(func(r rune) rune {
+			if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return '_'
+		}, key)
+		env = append(env, name+"="+conf[key])
+	}
+	return env
+}
@@ -0,0 +1,66 @@
+package mapreduce
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestStreaming(t *testing.T) {
+	var input []Pair
+	for i := 0; i < 100; i++ {
+		input = append(input, Pair{Key: fmt.Sprint(i), Value: fmt.Sprintf("a%d b%d", i%2, i%3)})
+	}
+	s := &Streaming{
+		Mapper:  `awk -F'\t' '{n = split($2, w, " "); for (i = 1; i <= n; i++) print w[i] "\t1"}'`,
+		Reducer: `awk -F'\t' '$1 != k {if (k != "") print k "\t" c; k = $1; c = 0} {c += $2} END {if (k != "") print k "\t" c}'`,
+	}
+	out, err := (&FakeCluster{M: 3, R: 2, TotalOrder: true}).Run(s, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "a0", Value: "50"}, {Key: "a1", Value: "50"}, {Key: "b0", Value: "34"}, {Key: "b1", Value: "33"}, {Key: "b2", Value: "33"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+}
+
+func TestStreamingEnvironment(t *testing.T) {
+	s := &Streaming{Mapper: `while read line; do printf '%s\t%s %s\n' "$MAPREDUCE_PHASE" "$MAPREDUCE_TASK" "$n_gram"; done`}
+	out, err := (&FakeCluster{M: 1, R: 1, Conf: JobConf{"n.gram": "3"}}).Run(s, []Pair{{Key: "k", Value: "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "map", Value: "0 3"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+}
+
+func TestStreamingFailures(t *testing.T) {
+	input := []Pair{{Key: "1", Value: "one"}, {Key: "2", Value: "two"}}
+	for _, s := range []*Streaming{
+		{Mapper: "cat", Reducer: "cat; exit 4"},
+		{Mapper: "exit 1"},
+		{Mapper: "no-such-command-here"},
+	} {
+		if _, err := (&FakeCluster{M: 1, R: 1}).Run(s, input); err == nil {
+			t.Errorf("mapper %q, reducer %q: job succeeded", s.Mapper, s.Reducer)
+		}
+	}
+	if _, err := (&FakeCluster{M: 1, R: 1}).Run(&Streaming{Mapper: "cat"}, []Pair{{Key: "a\tb", Value: "x"}}); err == nil {
+		t.Error("a key holding a tab was passed to the mapper")
+	}
+}
+
+func TestStreamingLongStderrLine(t *testing.T) {
+	// longer than any line buffer, and without a newline at the end
+	s := &Streaming{Mapper: `cat; head -c 3000000 /dev/zero | tr '\0' x >&2`}
+	out, err := (&FakeCluster{M: 1, R: 1}).Run(s, []Pair{{Key: "k", Value: "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Pair{{Key: "k", Value: "v"}}; !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+}
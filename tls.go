@@ -0,0 +1,194 @@
+package mapreduce
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// scheme used in data URLs; switched to https when certificates are configured
+var dataScheme = "http"
+
+// client used by download; replaced with one that trusts the cluster CA
+// (and presents this node's certificate) when TLS is on
+var dataClient = http.DefaultClient
+
+type tlsOptions struct {
+	CertFile      string // this node's certificate, PEM
+	KeyFile       string // this node's private key, PEM
+	CAFile        string // CA that signed the other nodes' certificates, PEM
+	VerifyClients bool   // require and verify client certificates
+}
+
+func (o tlsOptions) enabled() bool {
+	return o.CertFile != "" || o.KeyFile != ""
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("reading CA file", "file", path, "err", err)
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		err := fmt.Errorf("no certificates found in CA file %s", path)
+		slog.Error("loading CA file", "file", path, "err", err)
+		return nil, err
+	}
+	return pool, nil
+}
+
+// configs builds the server side config for the /data/ listener (and any
+// other endpoint a node serves) and the client side config for fetching
+// from other nodes
+func (o tlsOptions) configs() (server *tls.Config, client *tls.Config, err error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, nil, errors.New("TLS needs both a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		slog.Error("loading certificate", "file", o.CertFile, "key", o.KeyFile, "err", err)
+		return nil, nil, err
+	}
+	server = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.CAFile != "" {
+		pool, err := loadCertPool(o.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		server.ClientCAs = pool
+		client.RootCAs = pool
+	}
+	if o.VerifyClients {
+		if o.CAFile == "" {
+			return nil, nil, errors.New("verifying client certificates needs a CA file")
+		}
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return server, client, nil
+}
+
+// setupTLS switches data URLs and download over to TLS and returns the
+// config to wrap listeners with
+func setupTLS(o tlsOptions) (*tls.Config, error) {
+	server, client, err := o.configs()
+	if err != nil {
+		return nil, err
+	}
+	dataScheme = "https"
+	dataClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: client,
+		},
+	}
+	return server, nil
+}
+
+// generateTestCA writes a throwaway CA (ca.crt, ca.key) and one node
+// certificate signed by it (node.crt, node.key) into dir. The node
+// certificate is valid for the given hosts as both server and client, so
+// every machine in a test cluster can share it.
+func generateTestCA(dir string, hosts []string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		slog.Error("creating certificate directory", "file", dir, "err", err)
+		return err
+	}
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mapreduce test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		slog.Error("creating CA certificate", "err", err)
+		return err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+
+	nodeKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	nodeTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mapreduce node"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			nodeTemplate.IPAddresses = append(nodeTemplate.IPAddresses, ip)
+		} else {
+			nodeTemplate.DNSNames = append(nodeTemplate.DNSNames, host)
+		}
+	}
+	nodeDER, err := x509.CreateCertificate(rand.Reader, nodeTemplate, caCert, &nodeKey.PublicKey, caKey)
+	if err != nil {
+		slog.Error("creating node certificate", "err", err)
+		return err
+	}
+
+	if err := writePEM(filepath.Join(dir, "ca.crt"), "CERTIFICATE", caDER); err != nil {
+		return err
+	}
+	if err := writeKey(filepath.Join(dir, "ca.key"), caKey); err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(dir, "node.crt"), "CERTIFICATE", nodeDER); err != nil {
+		return err
+	}
+	return writeKey(filepath.Join(dir, "node.key"), nodeKey)
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(path, "EC PRIVATE KEY", der)
+}
+
+func writePEM(path, kind string, der []byte) error {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		slog.Error("writing PEM file", "file", path, "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package mapreduce
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSWithClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	if err := generateTestCA(dir, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	opts := tlsOptions{
+		CertFile:      filepath.Join(dir, "node.crt"),
+		KeyFile:       filepath.Join(dir, "node.key"),
+		CAFile:        filepath.Join(dir, "ca.crt"),
+		VerifyClients: true,
+	}
+	serverConfig, clientConfig, err := opts.configs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("node certificate refused: %v", err)
+	}
+	res.Body.Close()
+
+	// trusting the CA is not enough without a certificate of its own
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: clientConfig.RootCAs}}}
+	if res, err := anonymous.Get(server.URL); err == nil {
+		res.Body.Close()
+		t.Error("client without a certificate was let in")
+	}
+}
+
+func TestTLSOptionErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := generateTestCA(dir, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	cert, key := filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+	for name, opts := range map[string]tlsOptions{
+		"no key":              {CertFile: cert},
+		"verify without a CA": {CertFile: cert, KeyFile: key, VerifyClients: true},
+		"CA file not PEM":     {CertFile: cert, KeyFile: key, CAFile: key},
+	} {
+		if _, _, err := opts.configs(); err == nil {
+			t.Errorf("%s: config built", name)
+		}
+	}
+}
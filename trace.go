@@ -0,0 +1,128 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Span is one timed phase of a task, like a download or the user's Map calls
+type Span struct {
+	Name     string            `json:"name"`
+	Start    time.Time         `json:"start"`
+	Duration time.Duration     `json:"duration"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+// taskTrace collects the spans of one run of a task. A nil *taskTrace
+// records nothing.
+type taskTrace struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+// begin starts a span and returns the function that ends it. args are
+// name, value pairs shown with the span in the trace viewer.
+func (t *taskTrace) begin(name string, args ...string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		s := Span{Name: name, Start: start, Duration: time.Since(start)}
+		if len(args) > 0 {
+			s.Args = make(map[string]string)
+			for i := 0; i+1 < len(args); i += 2 {
+				s.Args[args[i]] = args[i+1]
+			}
+		}
+		t.mu.Lock()
+		t.spans = append(t.spans, s)
+		t.mu.Unlock()
+	}
+}
+
+func (t *taskTrace) Spans() []Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Span(nil), t.spans...)
+}
+
+// one entry in the Chrome trace event format, which chrome://tracing and
+// Perfetto can open
+type traceEvent struct {
+	Name  string            `json:"name"`
+	Cat   string            `json:"cat,omitempty"`
+	Phase string            `json:"ph"`
+	TS    int64             `json:"ts"`            // microseconds since the job started
+	Dur   int64             `json:"dur,omitempty"` // microseconds
+	PID   int               `json:"pid"`
+	TID   int               `json:"tid"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// writeTrace writes every finished task's spans as a Chrome trace. Each
+// worker is a process in the viewer and each task a thread within it.
+func (js *jobStatus) writeTrace(path string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	var events []traceEvent
+	pids := make(map[string]int)
+	micros := func(t time.Time) int64 {
+		return t.Sub(js.Started).Microseconds()
+	}
+	add := func(t *taskStatus, tid int) {
+		if t.Started.IsZero() {
+			return
+		}
+		pid, ok := pids[t.Worker]
+		if !ok {
+			pid = len(pids) + 1
+			pids[t.Worker] = pid
+			events = append(events, traceEvent{Name: "process_name", Phase: "M", PID: pid,
+				Args: map[string]string{"name": t.Worker}})
+		}
+		label := fmt.Sprintf("%s %d", t.Phase, t.N)
+		events = append(events, traceEvent{Name: "thread_name", Phase: "M", PID: pid, TID: tid,
+			Args: map[string]string{"name": label}})
+
+		end := t.Finished
+		if end.IsZero() {
+			end = time.Now()
+		}
+		events = append(events, traceEvent{Name: label, Cat: t.Phase, Phase: "X", PID: pid, TID: tid,
+			TS: micros(t.Started), Dur: end.Sub(t.Started).Microseconds(),
+			Args: map[string]string{"state": t.State, "attempts": fmt.Sprint(t.Attempts)}})
+		for _, s := range t.Spans {
+			events = append(events, traceEvent{Name: s.Name, Cat: t.Phase, Phase: "X", PID: pid, TID: tid,
+				TS: micros(s.Start), Dur: s.Duration.Microseconds(), Args: s.Args})
+		}
+	}
+	for _, t := range js.Maps {
+		add(t, t.N)
+	}
+	for _, t := range js.Combines {
+		add(t, len(js.Maps)+t.N)
+	}
+	for _, t := range js.Reduces {
+		add(t, len(js.Maps)+len(js.Combines)+t.N)
+	}
+
+	contents, err := json.Marshal(map[string]any{"traceEvents": events, "displayTimeUnit": "ms"})
+	if err != nil {
+		slog.Error("encoding trace", "err", err)
+		return err
+	}
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		slog.Error("writing trace", "file", path, "err", err)
+		return err
+	}
+	return nil
+}
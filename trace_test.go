@@ -0,0 +1,60 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceAfterJob(t *testing.T) {
+	fc := &FakeCluster{M: 2, R: 2, Workers: 2}
+	if _, err := fc.Run(&Client{}, lines(20, "the cat")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "trace.json")
+	if err := fc.Status.writeTrace(path); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(contents, &trace); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := make(map[string]int)
+	spans := make(map[string]int)
+	for _, e := range trace.TraceEvents {
+		if e.Phase != "X" {
+			continue
+		}
+		if e.TS < 0 || e.Dur < 0 {
+			t.Errorf("event %+v starts before the job or runs backwards", e)
+		}
+		switch e.Name {
+		case "map 0", "map 1", "reduce 0", "reduce 1":
+			tasks[e.Cat]++
+		default:
+			spans[e.Cat]++
+		}
+	}
+	if tasks["map"] != 2 || tasks["reduce"] != 2 {
+		t.Errorf("trace has tasks %v, want two of each", tasks)
+	}
+	if spans["map"] == 0 || spans["reduce"] == 0 {
+		t.Errorf("trace has no spans within tasks: %v", spans)
+	}
+}
+
+func TestNilTaskTrace(t *testing.T) {
+	var trace *taskTrace
+	trace.begin("download", "file", "x")()
+	if spans := trace.Spans(); spans != nil {
+		t.Errorf("nil trace recorded %v", spans)
+	}
+}
@@ -1,10 +1,11 @@
-package main
+package mapreduce
 
 import (
+	"crypto/tls"
 	"database/sql"
+	"flag"
 	"fmt"
-	"hash/fnv"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -12,26 +13,50 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 )
 
 var mu sync.Mutex
 
 type MapTask struct {
-	M, R       int    // total number of map and reduce tasks
-	N          int    // map task number, 0-based
-	SourceHost string // address of host with map input file
+	M, R       int        // total number of map and reduce tasks
+	N          int        // map task number, 0-based
+	SourceHost string     // address of host with map input file
+	Worker     string     // address of the worker running the task, for logging
+	Attempt    int        // which try at the task this is, 1-based, for logging
+	Bounds     []string   // R-1 range bounds for a total order; nil hashes keys
+	LookupHost string     // address serving the job's lookup table; "" if it has none
+	FilesHost  string     // address serving the job's side files
+	Files      []SideFile // side files to fetch before running
+	Conf       JobConf    // job parameters for Configure
+	Stats      TaskStats  // filled in by Process
+	Outputs    []string   // files written, filled in by Process
 }
 
 type ReduceTask struct {
-	M, R        int      // total number of map and reduce tasks
-	N           int      // reduce task number, 0-based
-	SourceHosts []string // addresses of map workers
+	M, R        int        // total number of map and reduce tasks
+	N           int        // reduce task number, 0-based
+	Partitions  []int      // partitions to reduce; nil means just partition N
+	SourceHosts []string   // addresses of map workers
+	FilesHost   string     // address serving the job's side files
+	Files       []SideFile // side files to fetch before running
+	Conf        JobConf    // job parameters for Configure
+	Worker      string     // address of the worker running the task, for logging
+	Attempt     int        // which try at the task this is, 1-based, for logging
+	Stats       TaskStats  // filled in by Process
+	Outputs     []string   // files written, main output first, filled in by Process
+
+	// hot keys whose values were combined ahead of time, and the address
+	// holding each salt's combine output for this partition
+	Hot          []string
+	CombineHosts []string
 }
 
 type Pair struct {
-	Key   string
-	Value string
+	Key    string
+	Value  string
+	Output string // named output to write to; "" is the main output. Map may only name one in a map-only job (R of 0)
 }
 
 type Interface interface {
@@ -39,7 +64,12 @@ type Interface interface {
 	Reduce(key string, values <-chan string, output chan<- Pair) error
 }
 
-type Client struct{}
+// Client counts words. Its counters and stop words come from the running
+// task's job context, so it has to be used through a pointer for
+// SetContext to see it.
+type Client struct {
+	ctx *JobContext
+}
 
 const (
 	mapSource = iota
@@ -79,15 +109,27 @@ func reduceTempFile(r int) string {
 	return fmt.Sprintf("reduce_%d_temp.db", r)
 }
 
+func combineInputFile(r, salt int) string {
+	return fmt.Sprintf("combine_%d_input_%d.db", r, salt)
+}
+
+func combineOutputFile(r, salt int) string {
+	return fmt.Sprintf("combine_%d_output_%d.db", r, salt)
+}
+
+func combineTempFile(r, salt int) string {
+	return fmt.Sprintf("combine_%d_temp_%d.db", r, salt)
+}
+
 func makeURL(host, file string) string {
-	return fmt.Sprintf("http://%s/data/%s", host, file)
+	return fmt.Sprintf("%s://%s/data/%s", dataScheme, host, file)
 }
 
 func getLocalAddress() string {
 	conn, err := net.Dial("udp", "8.8.8.8:8080")
 
 	if err != nil {
-		log.Fatalf("No, getLocalAddress did not work")
+		fatal("finding local address", "err", err)
 	}
 	defer conn.Close()
 
@@ -101,8 +143,15 @@ func getLocalAddress() string {
 	return localaddress
 }
 
-func (c Client) Map(key, value string, output chan<- Pair) error {
+func (c *Client) SetContext(ctx *JobContext) {
+	c.ctx = ctx
+}
+
+// Map counts the words of value, leaving out any in the job's lookup table
+// as stop words
+func (c *Client) Map(key, value string, output chan<- Pair) error {
 	defer close(output)
+	counters, stopWords := c.ctx.Counters(), c.ctx.Lookup()
 	lst := strings.Fields(value)
 	for _, elt := range lst {
 		word := strings.Map(func(r rune) rune {
@@ -111,14 +160,35 @@ func (c Client) Map(key, value string, output chan<- Pair) error {
 			}
 			return -1
 		}, elt)
-		if len(word) > 0 {
+		if _, stop := stopWords.Get(word); stop {
+			counters.Add("stop words", 1)
+		} else if len(word) > 0 {
 			output <- Pair{Key: word, Value: "1"}
+			counters.Add("words", 1)
+		} else {
+			counters.Add("tokens skipped", 1)
+		}
+	}
+	return nil
+}
+
+// Combine sums counts, so a hot word's partial counts can be summed again
+// by Reduce
+func (c *Client) Combine(key string, values <-chan string, output chan<- string) error {
+	defer close(output)
+	count := 0
+	for v := range values {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
 		}
+		count += i
 	}
+	output <- strconv.Itoa(count)
 	return nil
 }
 
-func (c Client) Reduce(key string, values <-chan string, output chan<- Pair) error {
+func (c *Client) Reduce(key string, values <-chan string, output chan<- Pair) error {
 	defer close(output)
 	count := 0
 	for v := range values {
@@ -131,6 +201,7 @@ func (c Client) Reduce(key string, values <-chan string, output chan<- Pair) err
 
 	p := Pair{Key: key, Value: strconv.Itoa(count)}
 	output <- p
+	c.ctx.Counters().Add("distinct words", 1)
 	return nil
 }
 
@@ -170,12 +241,17 @@ func getDatabase(path string) (*sql.DB, error) {
 }
 
 func InsertPair(r int, n int, db *sql.DB, pairs []Pair) error {
+	start := time.Now()
+	defer func() {
+		recordInserts(len(pairs), time.Since(start))
+	}()
+
 	for _, pair := range pairs {
 		// insert pairs into the output DB
 		_, err := db.Exec("INSERT INTO pairs (key, value) VALUES (?, ?)", pair.Key, pair.Value)
 		if err != nil {
 			db.Close()
-			log.Fatalf("InsertPair: error inserting pairs into database: %v", err)
+			slog.Error("inserting pairs into database", "task", n, "partition", r, "err", err)
 			return err
 		}
 	}
@@ -183,24 +259,49 @@ func InsertPair(r int, n int, db *sql.DB, pairs []Pair) error {
 	return nil
 }
 
-func (task *MapTask) Process(path string, client Interface) error {
+func (task *MapTask) Process(path string, client Interface) (err error) {
 	// make URL
 	sourceFile := mapSourceFile(task.N)
 	url := makeURL(task.SourceHost, sourceFile)
 	inputFile := filepath.Join(path, mapInputFile(task.N))
 
+	logger := task.logger()
+	trace := new(taskTrace)
+	if err := configure(logger, client, task.Conf); err != nil {
+		return err
+	}
+	order := keyOrderOf(client)
+	counters := NewCounters()
 	finished := make(chan bool, 1)
 
-	err := download(url, inputFile)
+	var lookup *Lookup
+	if task.LookupHost != "" {
+		var err error
+		if lookup, err = fetchLookup(logger, trace, task.LookupHost, path); err != nil {
+			return err
+		}
+	}
+	ctx, err := fetchSideFiles(logger, trace, task.FilesHost, path, task.Files)
 	if err != nil {
-		log.Printf("MapTask.Process: error in downloading path %s: %v", path, err)
+		return err
+	}
+	ctx.phase, ctx.conf, ctx.logger = "map", task.Conf, logger
+	ctx.counters, ctx.lookup = counters, lookup
+	setContext(client, ctx)
+
+	endDownload := trace.begin("download", "url", url)
+	err = download(logger, url, inputFile)
+	endDownload()
+	if err != nil {
+		logger.Error("downloading map input", "file", inputFile, "url", url, "err", err)
+		return err
 	}
 
 	var db *sql.DB
 
 	db, err = openDatabase(inputFile)
 	if err != nil {
-		log.Printf("error in opening inputFile")
+		logger.Error("opening map input", "file", inputFile, "err", err)
 		return err
 	}
 
@@ -208,33 +309,76 @@ func (task *MapTask) Process(path string, client Interface) error {
 		db.Close()
 		os.Remove(inputFile)
 	}()
-	
-	outs := make([][]Pair, task.R)
+
+	// a map-only job (R of 0) writes one main output instead of partitions
+	parts := max(task.R, 1)
+	outs := make([][]Pair, parts)
+	var named []Pair
 	dbs := []*sql.DB{}
 	defer func() {
-		if <-finished {
-			done_ := make(chan bool, 1)
-			go func() {
-				for r, elt := range outs {
-					if err := InsertPair(r, task.N, dbs[r], elt); err != nil {
-						fmt.Printf("MapTask.InsertPair: %v", err)
-					}
-					dbs[r].Close()
+		select {
+		case <-finished:
+		default:
+			// the task failed part way through, so its outputs are incomplete
+			for _, out := range dbs {
+				out.Close()
+			}
+			return
+		}
+		// a failed write fails the task, so that it is run again
+		done_ := make(chan error, 1)
+		go func() {
+			defer func() {
+				for _, out := range dbs {
+					out.Close()
 				}
-				done_ <- true
 			}()
-			if <-done_ {
-				return
+			for r, elt := range outs {
+				if order.custom() {
+					sortPairs(elt, order)
+				}
+				endInsert := trace.begin("insert", "partition", strconv.Itoa(r), "pairs", strconv.Itoa(len(elt)))
+				err := InsertPair(r, task.N, dbs[r], elt)
+				endInsert()
+				if err != nil {
+					logger.Error("writing map output", "partition", r, "err", err)
+					done_ <- err
+					return
+				}
+				dbs[r].Close()
+				outputFile := filepath.Join(path, task.outputFile(r))
+				if err := writeChecksum(outputFile); err != nil {
+					logger.Error("recording checksum", "file", outputFile, "err", err)
+					done_ <- err
+					return
+				}
+				if info, err := os.Stat(outputFile); err == nil {
+					task.Stats.Bytes += info.Size()
+				}
+				task.Outputs = append(task.Outputs, outputFile)
 			}
-		}
+			if _, byName, err := splitNamed(named); err == nil {
+				paths, err := writeNamedOutputs(logger, path, task.N, byName, func(name string) string {
+					return mapNamedOutputFile(task.N, name)
+				})
+				if err != nil {
+					logger.Error("writing named map output", "err", err)
+					done_ <- err
+					return
+				}
+				task.Outputs = append(task.Outputs, paths...)
+			}
+			task.Stats.Spans = trace.Spans()
+			done_ <- nil
+		}()
+		err = <-done_
 	}()
 
 	// create map output database
-	for i := 0; i < task.R; i++ {
-		outputDB := mapOutputFile(task.N, i)
+	for i := 0; i < parts; i++ {
+		outputDB := task.outputFile(i)
 		output_database, err := createDatabase(filepath.Join(path, outputDB))
 		if err != nil {
-			output_database.Close()
 			return err
 		}
 		dbs = append(dbs, output_database)
@@ -242,7 +386,7 @@ func (task *MapTask) Process(path string, client Interface) error {
 
 	rows, err := db.Query("select key, value from pairs")
 	if err != nil {
-		log.Printf("error in select query from database to get pairs: %v", err)
+		logger.Error("select query from database to get pairs", "file", inputFile, "err", err)
 		return err
 	}
 
@@ -253,136 +397,314 @@ func (task *MapTask) Process(path string, client Interface) error {
 	var value string
 	in_count, out_count := 0, 0
 
-	for rows.Next() {
-		if err = rows.Scan(&key, &value); err != nil {
-			log.Fatalf("MapTask.Process: error scanning rows: %v", err)
-		}
+	cleanup, err := setup(logger, client, task.N, ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
-		// call map
+	// collect runs one Map (or Flush) call and files what it outputs
+	collect := func(call func(output chan<- Pair) error) error {
 		output_ := make(chan Pair)
+		collected := make(chan bool)
+		var outputErr error
 
 		// output
 		go func() {
 			for pair := range output_ {
-				hash := fnv.New32()
-				hash.Write([]byte(pair.Key))
-				r := int(hash.Sum32() % uint32(task.R))
-				outs[r] = append(outs[r], pair)
 				out_count++
+				switch {
+				case pair.Output != "" && task.R > 0:
+					outputErr = fmt.Errorf("Map wrote to output %q, but only map-only jobs have named map outputs", pair.Output)
+				case pair.Output != "":
+					named = append(named, pair)
+				case task.R == 0:
+					outs[0] = append(outs[0], pair)
+				default:
+					r := task.partition(order, pair.Key)
+					outs[r] = append(outs[r], pair)
+				}
 			}
+			collected <- true
 		}()
 
-		err = client.Map(key, value, output_)
+		err := call(output_)
+		<-collected
+		if err == nil {
+			err = outputErr
+		}
+		return err
+	}
+
+	endMap := trace.begin("map")
+	for rows.Next() {
+		if err = rows.Scan(&key, &value); err != nil {
+			fatal("scanning map input rows", "phase", "map", "task", task.N, "file", inputFile, "err", err)
+		}
+
+		// call map
+		err = collect(func(output chan<- Pair) error {
+			return client.Map(key, value, output)
+		})
 		if err != nil {
-			log.Printf("Client.Map: %v", err)
+			logger.Error("Map", "key", key, "err", err)
+			return err
 		}
 
 		in_count++
 	}
+	if err := flush(logger, client, collect); err != nil {
+		return err
+	}
+	endMap()
+	if err := cleanup(); err != nil {
+		return err
+	}
+	if _, _, err := splitNamed(named); err != nil {
+		logger.Error("Map", "err", err)
+		return err
+	}
 
+	task.Stats.RecordsIn = in_count
+	task.Stats.RecordsOut = out_count
+	task.Stats.Counters = counters.Snapshot()
 	finished <- true
 
 	return err
 }
 
+// outputFile names the map output for partition r, or the single output
+// of a map-only task
+func (task *MapTask) outputFile(r int) string {
+	if task.R == 0 {
+		return mapOnlyOutputFile(task.N)
+	}
+	return mapOutputFile(task.N, r)
+}
+
+func (task *ReduceTask) partitions() []int {
+	if task.Partitions == nil {
+		return []int{task.N}
+	}
+	return task.Partitions
+}
+
 //Process for ReduceTask
 
 func (task *ReduceTask) Process(path string, client Interface) error {
+	logger := task.logger()
+	trace := new(taskTrace)
+	if err := configure(logger, client, task.Conf); err != nil {
+		return err
+	}
+	order := keyOrderOf(client)
+	counters := NewCounters()
+	ctx, err := fetchSideFiles(logger, trace, task.FilesHost, path, task.Files)
+	if err != nil {
+		return err
+	}
+	ctx.phase, ctx.conf, ctx.logger = "reduce", task.Conf, logger
+	ctx.counters = counters
+	setContext(client, ctx)
+
 	var reduce_temp_files []string
-	m := 0
-	for m < task.M {
-		file := mapOutputFile(m, task.N)
-		url := makeURL(task.SourceHosts[m], file)
-		reduce_temp_files = append(reduce_temp_files, url)
-		m++
+	for _, p := range task.partitions() {
+		m := 0
+		for m < task.M {
+			file := mapOutputFile(m, p)
+			url := makeURL(task.SourceHosts[m], file)
+			reduce_temp_files = append(reduce_temp_files, url)
+			m++
+		}
 	}
 
-	db, err := mergeDatabases(reduce_temp_files, filepath.Join(path, reduceInputFile(task.N)), reduceTempFile(task.N))
+	db, bytes, err := mergeDatabases(logger, trace, reduce_temp_files, filepath.Join(path, reduceInputFile(task.N)), filepath.Join(path, reduceTempFile(task.N)))
 	if err != nil {
-		log.Fatalf("No, merge did not work for some reason %v", err)
-		//return err
+		logger.Error("merging reduce input", "err", err)
+		return err
+	}
+	defer db.Close()
+	task.Stats.Bytes = bytes
+	if len(task.Hot) > 0 {
+		combined, err := task.mergeCombined(logger, trace, path, db)
+		if err != nil {
+			logger.Error("merging combine output", "err", err)
+			return err
+		}
+		task.Stats.Bytes += combined
 	}
 
 	// create output file
-	reduceOutputFile := reduceOutputFile(task.N)
-
-	//reduceOutputFile = reduceOutputFile
+	reduceOutputFile := filepath.Join(path, reduceOutputFile(task.N))
 
 	// create that database
-	reduceDB, err := createDatabase(filepath.Join(path, reduceOutputFile))
+	reduceDB, err := createDatabase(reduceOutputFile)
 
 	if err != nil {
-		log.Fatalf("No")
+		logger.Error("creating reduce output", "file", reduceOutputFile, "err", err)
+		return err
 	}
 
-	reduceDB = reduceDB
+	defer reduceDB.Close()
 
-	var key string
-	var value string
+	// sqlite does the sort when the first row is read, unless the job
+	// has its own key order
+	endSort := trace.begin("sort")
+	rows, err := readReduceInput(db, order)
+	if err != nil {
+		logger.Error("select query from database to get pairs", "file", reduceInputFile(task.N), "err", err)
+		return err
+	}
+	defer rows.Close()
 
-	var keys []string
-	values := make(chan string)
+	cleanup, err := setup(logger, client, task.N, ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
-	done := make(chan bool, 1)
+	// each key gets its own Reduce call fed through values; outputs from
+	// every call are collected into outs
 	var outs []Pair
-	defer func() {
-		if <-done {
-			fmt.Println(outs)
-		}
-	}()
-
-	rows, _ := db.Query("select key, value from pairs order by key, value")
+	var values chan string
+	var finished chan error
 
-	defer rows.Close()
+	// close out the current key and wait for its Reduce call to return
+	endGroup := func() error {
+		if values == nil {
+			return nil
+		}
+		close(values)
+		values = nil
+		return <-finished
+	}
 
-	// variables
 	previous := ""
+	in_count := 0
+	// set when Reduce returns before reading all of its key's values, such
+	// as one that only wants the first; the rest are read and dropped
+	returned := false
 
+	var endReduce func()
 	for rows.Next() {
-
-		if err := rows.Scan(&key, &value); err != nil {
-			return err
+		if endReduce == nil {
+			endSort()
+			endReduce = trace.begin("reduce")
 		}
 
-		output := make(chan Pair)
+		pair := rows.Pair()
+		key, value := order.group(pair.Key), pair.Value
 
-		go func() {
-			//output <- Pair{Value: value, Key: key}
-			//new_value := <-values
-			//new_value = new_value
-
-			//fmt.Println("Went here")
-
-			for pair := range output {
-				outs = append(outs, pair)
-				//fmt.Println(pair, "pair")
+		if (values == nil && !returned) || !order.compare.same(previous, key) {
+			if err := endGroup(); err != nil {
+				logger.Error("Reduce", "key", previous, "err", err)
+				return err
 			}
-			return
-		}()
-
-		if previous != key {
 			previous = key
-			keys = append(keys, previous)
-			close(values)
-			finished := make(chan error)
-			values = make(chan string)
-			
+			returned = false
+
+			output := make(chan Pair)
+			collected := make(chan bool)
 			go func() {
-				err = client.Reduce(key, values, output)
-				if err != nil {
-					log.Printf("Client.Reduce: %v", err)
+				for pair := range output {
+					outs = append(outs, pair)
 				}
-				finished <- err
-				return
+				collected <- true
 			}()
+
+			values = make(chan string)
+			finished = make(chan error, 1)
+			go func(key string, values <-chan string, finished chan<- error) {
+				err := client.Reduce(key, values, output)
+				<-collected
+				finished <- err
+			}(key, values, finished)
 		}
-		values <- value
-	}
 
-	done <- true
+		in_count++
+		if returned {
+			continue
+		}
 
-	db.Close()
+		// Reduce may give up on a key before reading all of its values
+		select {
+		case values <- value:
+		case err := <-finished:
+			values = nil
+			if err != nil {
+				logger.Error("Reduce", "key", key, "err", err)
+				return err
+			}
+			returned = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("iterating over reduce input", "file", reduceInputFile(task.N), "err", err)
+		return err
+	}
+	if err := endGroup(); err != nil {
+		logger.Error("Reduce", "key", previous, "err", err)
+		return err
+	}
+	err = flush(logger, client, func(call func(output chan<- Pair) error) error {
+		output := make(chan Pair)
+		collected := make(chan bool)
+		go func() {
+			for pair := range output {
+				outs = append(outs, pair)
+			}
+			collected <- true
+		}()
+		err := call(output)
+		<-collected
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if endReduce == nil {
+		endSort()
+	} else {
+		endReduce()
+	}
+	if err := cleanup(); err != nil {
+		return err
+	}
 
+	// output keys aren't composite, so only the key order applies
+	if order.compare != nil {
+		sortPairs(outs, keyOrder{compare: order.compare})
+	}
+	unnamed, named, err := splitNamed(outs)
+	if err != nil {
+		logger.Error("Reduce", "err", err)
+		return err
+	}
+	endInsert := trace.begin("insert", "pairs", strconv.Itoa(len(outs)))
+	err = InsertPair(task.N, task.N, reduceDB, unnamed)
+	endInsert()
+	if err != nil {
+		return err
+	}
+	if err := reduceDB.Close(); err != nil {
+		logger.Error("closing reduce output database", "file", reduceOutputFile, "err", err)
+		return err
+	}
+	if err := writeChecksum(reduceOutputFile); err != nil {
+		return err
+	}
+	namedFiles, err := writeNamedOutputs(logger, path, task.N, named, func(name string) string {
+		return reduceNamedOutputFile(task.N, name)
+	})
+	if err != nil {
+		return err
+	}
+	task.Outputs = append([]string{reduceOutputFile}, namedFiles...)
+
+	task.Stats.RecordsIn = in_count
+	task.Stats.RecordsOut = len(outs)
+	task.Stats.Counters = counters.Snapshot()
+	task.Stats.Spans = trace.Spans()
 	return nil
 
 	// everything works above
@@ -436,169 +758,251 @@ func (task *ReduceTask) Process(path string, client Interface) error {
 
 }
 
-func main() {
-
-	// Introduction
-	log.Print("Map Reduce -- Part 1")
-	log.Print("By: Jordan Coleman & Hailey Whipple")
-
-	//path := "source.db"
-	source := "austen.db"
-
-	number_of_rows, _ := getNumberOfRows(source)
-	page_count, _, _ := getDatabaseSize(source)
-
-	var m int = number_of_rows / page_count
-	var r int = m / 2
-
-	//m := 11
-	//r := 5
-
-	//source := "austin.db"
+// startServer serves the intermediate files, job status, dashboard and
+// metrics on address in the background
+func startServer(address string, tlsOpts tlsOptions, data, status http.Handler) {
+	slog.Info("starting http server", "worker", address)
+	slog.Info("job status available", "url", fmt.Sprintf("%s://%s/dashboard", dataScheme, address))
 
-	tmp := os.TempDir()
+	http.Handle("/data/", data)
+	http.Handle("/status", status)
+	http.HandleFunc("/dashboard", serveDashboard)
+	http.HandleFunc("/metrics", serveMetrics)
 
-	tempdir := filepath.Join(tmp, fmt.Sprintf("mapreduce.%d", os.Getpid()))
+	listener, err := net.Listen("tcp", address)
 
-	//fmt.Println("Temp Dir ", tempdir)
-
-	if err := os.RemoveAll(tempdir); err != nil {
-		log.Fatalf("unable to delete old temp dir: %v", err)
-	}
-	if err := os.Mkdir(tempdir, 0700); err != nil {
-		log.Fatalf("Was unable to make a temp dir")
-	}
-	defer os.RemoveAll(tempdir)
-
-	log.Printf("splitting %s into %d pieces", source, m)
-
-	var paths []string
-
-	paths = createPaths(m, mapSource, tempdir)
-
-	//for i := 0; i < m; i++ {
-
-	//paths = createPaths(m, mapSource, tempdir)
-	//paths_map_input := createPaths(m, mapInput, tempdir)
-	//paths_map_output := createPaths(m, mapOutput, tempdir)
-	//paths_reduce_input := createPaths(m, reduceInput, tempdir)
-
-	//fmt.Println("\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n", paths3, "\n\n\n\n\n\n\n\n\n\n\n\n\n\n")
-	//}
-
-	/*
-		for i := 0; i < m; i++ {
-			paths = append(paths, filepath.Join(tempdir, mapSourceFile(i)))
-		}
-	*/
-
-	if err := splitDatabase(source, paths); err != nil {
-		log.Fatalf("splitting database: %v", err)
+	if err != nil {
+		fatal("listening", "worker", address, "err", err)
 	}
-
-	/*
-		if err := splitDatabase(source, paths_map_input); err != nil {
-			log.Fatalf("splitting database: %v", err)
-		}
-		if err := splitDatabase(source, paths_map_output); err != nil {
-			log.Fatalf("splitting database: %v", err)
+	if tlsOpts.enabled() {
+		config, err := setupTLS(tlsOpts)
+		if err != nil {
+			fatal("setting up TLS", "err", err)
 		}
-		if err := splitDatabase(source, paths_reduce_input); err != nil {
-			log.Fatalf("splitting database: %v", err)
-		}*/
-
-	the_address := net.JoinHostPort(getLocalAddress(), "8080")
-	log.Print("Here is a new address that we are starting an http server with and it is ", the_address)
-
-	http.Handle("/data/", http.StripPrefix("/data", http.FileServer(http.Dir(tempdir))))
-
-	listener, err := net.Listen("tcp", the_address)
-
-	if err != nil {
-		log.Fatalf("There was a listen error. Here are some things to consider: ", listener, err)
+		listener = tls.NewListener(listener, config)
 	}
 	go func() {
 		if err := http.Serve(listener, nil); err != nil {
-			log.Fatalf("There was an error with Serve for some reason")
+			fatal("serving http", "worker", address, "err", err)
 		}
 
 	}()
+}
 
-	var mapTasks []*MapTask
+// Main runs the mapreduce command as its flags and arguments say: a new
+// job, a resume or a job spec file. cmd/mapreduce calls it.
+func Main() {
+	var tlsOpts tlsOptions
+	var genCA string
+	var logFormat, logLevel string
+	var traceFile string
+	var journalFile string
+	var keyOrder string
+	var totalOrder bool
+	var coalesceBytes int64
+	var joinSources, joinKind string
+	var lookupSource string
+	var sideFiles string
+	var mapper, reducer string
+	conf := make(JobConf)
+	var specFile string
+	var target string
+	flag.BoolVar(&compressTransfers, "compress", false, "gzip intermediate files sent between workers")
+	flag.StringVar(&tlsOpts.CertFile, "cert", "", "certificate (PEM) to serve intermediate files over TLS")
+	flag.StringVar(&tlsOpts.KeyFile, "key", "", "private key (PEM) for -cert")
+	flag.StringVar(&tlsOpts.CAFile, "ca", "", "CA certificate (PEM) that signed the other nodes' certificates")
+	flag.BoolVar(&tlsOpts.VerifyClients, "verify-clients", false, "require client certificates signed by -ca")
+	flag.StringVar(&dataToken, "token", "", "shared bearer token required to fetch intermediate files")
+	flag.StringVar(&genCA, "gen-ca", "", "write a test CA and node certificate into this directory and exit")
+	flag.StringVar(&traceFile, "trace", "", "write a Chrome trace of every task's phases to this file")
+	flag.StringVar(&journalFile, "journal", filepath.Join(os.TempDir(), "mapreduce_jobs.db"), "database recording job progress for resume")
+	flag.StringVar(&keyOrder, "key-order", "bytes", "reduce key order: bytes, numeric or nocase, optionally prefixed with reverse-")
+	flag.BoolVar(&totalOrder, "total-order", false, "partition keys by sampled ranges so the reduce outputs are globally sorted")
+	flag.Int64Var(&coalesceBytes, "coalesce-bytes", 64<<20, "merge neighbouring reduce partitions with less map output than this into one task; 0 turns it off")
+	flag.StringVar(&joinSources, "join", "", "comma separated source databases to join on key instead of counting words in austen.db")
+	flag.StringVar(&joinKind, "join-kind", "inner", "join to run with -join: inner, left or full")
+	flag.StringVar(&lookupSource, "lookup", "", "small database loaded into every map task; word count leaves out its keys as stop words")
+	flag.StringVar(&mapper, "mapper", "", "command run through sh -c as the mapper, reading and writing key<tab>value lines, instead of counting words")
+	flag.StringVar(&reducer, "reducer", "", "command run through sh -c as the reducer, reading key<tab>value lines sorted by key; empty with -mapper passes values through")
+	flag.StringVar(&sideFiles, "files", "", "comma separated side files copied to every worker before its tasks run")
+	flag.Var(confFlag(conf), "conf", "job parameter key=value handed to the client's Configure method; repeat for more")
+	flag.StringVar(&specFile, "spec", "", "JSON job spec file describing a DAG of stages or an iterative job to run instead of a single job")
+	flag.StringVar(&target, "target", "target.db", "database to gather the job's output into, with a target_<name>.db beside it per named output; empty leaves the outputs in the temp dir")
+	flag.StringVar(&logFormat, "log-format", "text", "log output format: text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "lowest level to log: debug, info, warn or error")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s [flags] resume <jobid>\n       %s [flags] -spec <file>\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// a new job, or one that stopped part way through
+	jobID := newJobID()
+	resume := false
+	switch {
+	case flag.NArg() == 0:
+	case flag.NArg() == 2 && flag.Arg(0) == "resume":
+		jobID = flag.Arg(1)
+		resume = true
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	//defer os.RemoveAll(tempdir)
+	if err := setupLogging(logFormat, logLevel, jobID); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	// This is where we are building our map tasks
-	for i := 0; i < m; i++ {
-		task := &MapTask{
-			M:          m,
-			R:          r,
-			N:          i,
-			SourceHost: the_address,
+	// what the job computes, which a resume takes from the journal
+	//path := "source.db"
+	source := "austen.db"
+	spec := &JobSpec{
+		Sources:       []string{source},
+		KeyOrder:      keyOrder,
+		TotalOrder:    totalOrder,
+		CoalesceBytes: coalesceBytes,
+		Lookup:        lookupSource,
+		Conf:          conf,
+		Mapper:        mapper,
+		Reducer:       reducer,
+	}
+	if joinSources != "" {
+		spec.Sources = strings.Split(joinSources, ",")
+		spec.Join = joinKind
+	}
+	if sideFiles != "" {
+		spec.Files = strings.Split(sideFiles, ",")
+	}
+	if _, err := parseJoinKind(joinKind); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if _, err := spec.client(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	specFlags := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "key-order", "total-order", "coalesce-bytes", "join", "join-kind", "lookup", "files", "conf", "mapper", "reducer":
+			specFlags = true
 		}
-		mapTasks = append(mapTasks, task)
+	})
+	if specFile != "" && (resume || specFlags) {
+		fmt.Fprintln(os.Stderr, "-spec takes the job settings from the file, and reruns it to resume")
+		os.Exit(2)
 	}
 
-	// This is where we are building our reduce tasks
-
-	var reduceTasks []*ReduceTask
-
-	for i := 0; i < r; i++ {
-		task := &ReduceTask{
-			M:           m,
-			R:           r,
-			N:           i,
-			SourceHosts: make([]string, m),
+	if genCA != "" {
+		if err := generateTestCA(genCA, []string{getLocalAddress(), "localhost", "127.0.0.1"}); err != nil {
+			fatal("generating test CA", "err", err)
 		}
-		reduceTasks = append(reduceTasks, task)
+		slog.Info("wrote ca.crt, ca.key, node.crt and node.key", "file", genCA)
+		return
 	}
 
-	var client Client
+	// Introduction
+	slog.Info("Map Reduce -- Part 1")
+	slog.Info("By: Jordan Coleman & Hailey Whipple")
 
-	// This is where we are processing the map tasks
-	for i, task := range mapTasks {
-		if err := task.Process(tempdir, client); err != nil {
-			log.Fatalf("there was an error with processing the maptask: ", i, err)
-		}
-		for _, reduce := range reduceTasks {
-			reduce.SourceHosts[i] = the_address //Question: Why are we passing in the same address here everytime?
+	journal, err := openJournal(journalFile)
+	if err != nil {
+		fatal("opening journal", "file", journalFile, "err", err)
+	}
+	defer journal.Close()
+
+	if specFile != "" {
+		address := net.JoinHostPort(getLocalAddress(), "8080")
+		data, status := new(handlerSwitch), new(handlerSwitch)
+		startServer(address, tlsOpts, data, status)
+		if err := runSpecFile(specFile, address, target, data, status, journal); err != nil {
+			// the temp dir and journal are left in place for a rerun
+			fatal("running job spec", "file", specFile, "err", err)
 		}
+		logTransferStats()
+		return
 	}
 
-	//fmt.Println(tmp)
-	//fmt.Println(tempdir)
-	log.Println("processed all of map tasks")
+	var job *Job
+	if resume {
+		if job, err = journal.loadJob(jobID); err != nil {
+			fatal("loading job to resume", "err", err)
+		}
+		switch {
+		case job.Spec == nil:
+			// journaled before job settings were recorded
+			slog.Warn("journal has no settings for the job, taking them from the flags")
+			spec.Sources, spec.TotalOrder = job.Sources, job.TotalOrder
+		case specFlags && !job.Spec.same(spec):
+			fatal("job settings differ from the ones the job started with; resume without them")
+		default:
+			spec = job.Spec
+		}
+		slog.Info("resuming job", "file", job.source(), "m", job.M, "r", job.R)
+	} else {
+		number_of_rows, page_count := 0, 0
+		for _, source := range spec.Sources {
+			rows, err := getNumberOfRows(source)
+			if err != nil {
+				fatal("reading source", "file", source, "err", err)
+			}
+			pages, _, err := getDatabaseSize(source)
+			if err != nil {
+				fatal("reading source", "file", source, "err", err)
+			}
+			number_of_rows += rows
+			page_count += pages
+		}
 
-	//This is where we are processing the reduce tasks
+		var m int = max(1, number_of_rows/page_count)
+		var r int = max(1, m/2)
 
-	//fmt.Println("\n\n\n\n\n\n\n\n\n", len(reduceTasks), "\n\n\n\n\n\n")
+		// the temp dir is named after the job so a resume can find it
+		tempdir := filepath.Join(os.TempDir(), fmt.Sprintf("mapreduce.%s", jobID))
+		if err := os.RemoveAll(tempdir); err != nil {
+			fatal("deleting old temp dir", "file", tempdir, "err", err)
+		}
+		if err := os.Mkdir(tempdir, 0700); err != nil {
+			fatal("making temp dir", "file", tempdir, "err", err)
+		}
 
-	for i, task := range reduceTasks {
-		//r_path := filepath.Join(tempdir, paths_reduce_input[i])
-		if err := task.Process(tempdir, client); err != nil {
-			//if err := task.Process(tempdir, client, paths_reduce_input[i]); err != nil { //
-			log.Fatalf("there was an error with processing the reduce task: ", i, err)
+		job = &Job{ID: jobID, Sources: spec.Sources, M: m, R: r, TempDir: tempdir, Spec: spec}
+		if err := journal.createJob(job); err != nil {
+			fatal("recording job", "err", err)
 		}
 	}
 
-	log.Print("Processed all of reduce tasks")
-
-	/* NEXT STEP IS WE NEED TO GATHER OUTPUTS INTO FINAL target.db FILE
-
-	//This is what we wrote last time
+	if err := spec.apply(job); err != nil {
+		fatal("setting up job", "err", err)
+	}
+	job.Journal = journal
+	job.Address = net.JoinHostPort(getLocalAddress(), "8080")
+	job.Status = newJobStatus(job.source(), job.M, job.R)
 
-	//client := new(Interface)
-	//shell(client)
+	startServer(job.Address, tlsOpts, http.StripPrefix("/data", newDataHandler(job.TempDir, job.M, job.R, dataToken)), job.Status)
 
-	*/
+	if err := job.Run(); err != nil {
+		// the temp dir and journal are left in place for resume
+		fatal("running job", "err", err, "resume", fmt.Sprintf("%s resume %s", os.Args[0], job.ID))
+	}
 
-	//go func() {
-	//	http.Handle("/data/", http.StripPrefix("/data", http.FileServer(http.Dir(tempdir))))
-	//	if err := http.ListenAndServe(the_address, nil); err != nil {
-	//		log.Printf("Error in HTTP server for %s: %v", the_address, err)
-	//	}
-	//}()
+	logTransferStats()
+	logCounters(job.Status.Counters)
+	if traceFile != "" {
+		if err := job.Status.writeTrace(traceFile); err == nil {
+			slog.Info("wrote job trace", "file", traceFile)
+		}
+	}
 
+	if target == "" {
+		slog.Info("job outputs left in place", "dir", job.TempDir)
+		return
+	}
+	if err := job.Gather(target); err != nil {
+		fatal("gathering job output", "target", target, "err", err)
+	}
+	os.RemoveAll(job.TempDir)
 }
 
-// go run *.go
+// go run ./cmd/mapreduce
@@ -0,0 +1,163 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lines builds n copies of the same line as source records
+func lines(n int, line string) []Pair {
+	var input []Pair
+	for i := 0; i < n; i++ {
+		input = append(input, Pair{Key: fmt.Sprint(i), Value: line})
+	}
+	return input
+}
+
+func TestWordCount(t *testing.T) {
+	fc := &FakeCluster{M: 4, R: 3, Workers: 2}
+	out, err := fc.Run(&Client{}, lines(200, "The cat and the dog."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "and", Value: "200"}, {Key: "cat", Value: "200"}, {Key: "dog", Value: "200"}, {Key: "the", Value: "400"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+	if got := fc.Status.Counters.Get("words"); got != 1000 {
+		t.Errorf("words counter is %d, want 1000", got)
+	}
+}
+
+func TestRetriesInjectedFailures(t *testing.T) {
+	input := lines(200, "the cat and the dog")
+	want, err := (&FakeCluster{M: 4, R: 3}).Run(&Client{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fc := &FakeCluster{M: 4, R: 3, Workers: 2, MaxAttempts: 3, Failures: []Failure{
+		{Kind: FailFetch, Phase: "reduce", Task: 1, Times: 2},
+		{Kind: CorruptFetch, Phase: "map", Task: 2},
+		{Kind: FailTask, Phase: "map", Task: 0},
+		{Kind: FailTask, Phase: "reduce", Task: 2},
+	}}
+	out, err := fc.Run(&Client{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v after retries, want %v", out, want)
+	}
+}
+
+func TestFailsWithoutRetries(t *testing.T) {
+	// download retries a fetch on its own, so fetch faults have to outlast
+	// its tries
+	for _, kind := range []FailureKind{FailFetch, CorruptFetch, FailTask} {
+		fc := &FakeCluster{M: 2, R: 2, Failures: []Failure{{Kind: kind, Phase: "reduce", Task: 1, Times: 10}}}
+		_, err := fc.Run(&Client{}, lines(10, "the cat"))
+		if err == nil {
+			t.Errorf("failure kind %d: job succeeded", kind)
+		}
+		if kind == FailTask && !errors.Is(err, errInjected) {
+			t.Errorf("failed task: got %v, want the injected error", err)
+		}
+	}
+}
+
+// firstValue keeps only the first value of each key, leaving the rest
+// unread, and fails on the key "fail" once it has read one value
+type firstValue struct{}
+
+func (firstValue) Map(key, value string, output chan<- Pair) error {
+	defer close(output)
+	word, n, _ := strings.Cut(value, " ")
+	output <- Pair{Key: word, Value: n}
+	return nil
+}
+
+func (firstValue) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	defer close(output)
+	first := <-values
+	if key == "fail" {
+		return errors.New("no fail keys")
+	}
+	output <- Pair{Key: key, Value: first}
+	return nil
+}
+
+func TestReduceReturningEarly(t *testing.T) {
+	var input []Pair
+	for i := 0; i < 50; i++ {
+		for _, word := range []string{"a", "b", "c"} {
+			input = append(input, Pair{Key: fmt.Sprint(len(input)), Value: fmt.Sprintf("%s %03d", word, i)})
+		}
+	}
+	fc := &FakeCluster{M: 3, R: 1}
+	out, err := fc.Run(firstValue{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 {
+		t.Errorf("got %v, want one pair per key", out)
+	}
+	if got := fc.Status.Reduces[0].RecordsIn; got != 150 {
+		t.Errorf("reduce read %d records, want 150", got)
+	}
+
+	input = append(input, Pair{Key: "fail", Value: "fail 1"}, Pair{Key: "fail 2", Value: "fail 2"})
+	if _, err := (&FakeCluster{M: 3, R: 1}).Run(firstValue{}, input); err == nil {
+		t.Error("a Reduce returning an error did not fail the job")
+	}
+}
+
+// longWords embeds Client but only keeps words with more than three
+// letters
+type longWords struct{ Client }
+
+func (l *longWords) Reduce(key string, values <-chan string, output chan<- Pair) error {
+	if len(key) <= 3 {
+		defer close(output)
+		for range values {
+		}
+		l.ctx.Counters().Add("short words", 1)
+		return nil
+	}
+	return l.Client.Reduce(key, values, output)
+}
+
+func TestEmbeddedClientKeepsOverrides(t *testing.T) {
+	fc := &FakeCluster{M: 2, R: 2}
+	out, err := fc.Run(&longWords{}, lines(20, "the quick brown fox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "brown", Value: "20"}, {Key: "quick", Value: "20"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+	for name, want := range map[string]int64{"words": 80, "short words": 2, "distinct words": 2} {
+		if got := fc.Status.Counters.Get(name); got != want {
+			t.Errorf("counter %q is %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestStopWordsFromLookup(t *testing.T) {
+	fc := &FakeCluster{M: 2, R: 1, Lookup: []Pair{{Key: "the"}, {Key: "and"}}}
+	out, err := fc.Run(&Client{}, lines(10, "the cat and the dog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{Key: "cat", Value: "10"}, {Key: "dog", Value: "10"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+	if got := fc.Status.Counters.Get("stop words"); got != 30 {
+		t.Errorf("stop words counter is %d, want 30", got)
+	}
+}